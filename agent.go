@@ -26,6 +26,9 @@ const (
 	rsaBitSize                      = 2048
 	agentJWTIssuer                  = "kubejob"
 	agentPublicKeyPEMName           = "AGENT_PUBLIC_KEY_PEM"
+	agentServiceAccountTokenName    = "kubejob-agent-service-account-token"
+	agentServiceAccountTokenPath    = "/var/run/secrets/kubejob/serviceaccount"
+	agentServiceAccountTokenExpire  = int64(3600)
 )
 
 type AgentConfig struct {
@@ -36,6 +39,7 @@ type AgentConfig struct {
 	portMapMu                       sync.RWMutex
 	privateKey                      *rsa.PrivateKey
 	publicKeyPEM                    string
+	mountServiceAccountToken        bool
 }
 
 func NewAgentConfig(containerNameToInstalledPathMap map[string]string) (*AgentConfig, error) {
@@ -87,6 +91,39 @@ func (c *AgentConfig) PublicKeyEnv() corev1.EnvVar {
 	}
 }
 
+// SetServiceAccountToken mounts the projected service account token to the containers using the agent.
+// The token is mounted at /var/run/secrets/kubejob/serviceaccount/token.
+func (c *AgentConfig) SetServiceAccountToken(mount bool) {
+	c.mountServiceAccountToken = mount
+}
+
+func (c *AgentConfig) ServiceAccountTokenVolume() corev1.Volume {
+	expirationSeconds := agentServiceAccountTokenExpire
+	return corev1.Volume{
+		Name: agentServiceAccountTokenName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Path:              "token",
+							ExpirationSeconds: &expirationSeconds,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (c *AgentConfig) ServiceAccountTokenVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      agentServiceAccountTokenName,
+		MountPath: agentServiceAccountTokenPath,
+		ReadOnly:  true,
+	}
+}
+
 func (c *AgentConfig) SetAllocationStartPort(port uint16) {
 	c.allocationStartPort = port
 }
@@ -173,6 +210,9 @@ func agentAuthStreamInterceptor(signedToken string) grpc.StreamClientInterceptor
 }
 
 func archivePath(targetPath string) (string, error) {
+	if _, err := os.Stat(targetPath); err != nil {
+		return "", fmt.Errorf("failed to get file info of src path %s: %w", targetPath, err)
+	}
 	archivedFilePath := fmt.Sprintf("%s.tar", targetPath)
 	dst, err := os.Create(archivedFilePath)
 	if err != nil {
@@ -277,6 +317,30 @@ func extractArchivedFile(filePath string, dstPath string) error {
 	return nil
 }
 
+// archivedFileStats counts the regular files and their size in the archived file.
+func archivedFileStats(filePath string, stats *CopyStats) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("failed to read archived file %s: %w", filePath, err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			stats.Files++
+			stats.Bytes += header.Size
+		}
+	}
+	return nil
+}
+
 func createFile(path string, mode int64, tr *tar.Reader) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
@@ -72,6 +72,10 @@ func (c *AgentClient) Exec(ctx context.Context, command []string, env []corev1.E
 }
 
 func (c *AgentClient) CopyFrom(ctx context.Context, srcPath, dstPath string) error {
+	return c.copyFromWithStats(ctx, srcPath, dstPath, nil)
+}
+
+func (c *AgentClient) copyFromWithStats(ctx context.Context, srcPath, dstPath string, stats *CopyStats) error {
 	finfo, err := os.Stat(dstPath)
 	archivedFilePath := fmt.Sprintf("%s.tar", dstPath)
 	if err == nil && finfo.IsDir() {
@@ -82,6 +86,11 @@ func (c *AgentClient) CopyFrom(ctx context.Context, srcPath, dstPath string) err
 	if err := c.copyFrom(ctx, srcPath, archivedFilePath); err != nil {
 		return err
 	}
+	if stats != nil {
+		if err := archivedFileStats(archivedFilePath, stats); err != nil {
+			return err
+		}
+	}
 	if err := extractArchivedFile(archivedFilePath, dstPath); err != nil {
 		return fmt.Errorf("failed to extract file %s: %w", archivedFilePath, err)
 	}
@@ -135,6 +144,7 @@ func (c *AgentClient) CopyTo(ctx context.Context, srcPath, dstPath string) error
 	if err != nil {
 		return err
 	}
+	defer os.Remove(archivedFilePath) // ignore error
 	f, err := os.Open(archivedFilePath)
 	if err != nil {
 		return fmt.Errorf("job: failed to open archived file %s: %w", archivedFilePath, err)
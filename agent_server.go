@@ -69,6 +69,8 @@ func (s *AgentServer) CopyFrom(req *agent.CopyFromRequest, stream agent.Agent_Co
 	log.Println("received copyFrom request")
 	if err := s.copyFrom(req, stream); err != nil {
 		log.Println(err)
+		// return the error to the client, otherwise the client receives the broken archive.
+		return err
 	}
 	return nil
 }
@@ -78,6 +80,7 @@ func (s *AgentServer) copyFrom(req *agent.CopyFromRequest, stream agent.Agent_Co
 	if err != nil {
 		return err
 	}
+	defer os.Remove(archivedFilePath) // ignore error
 	f, err := os.Open(archivedFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to open %s: %w", archivedFilePath, err)
@@ -323,6 +323,124 @@ func TestAgentServer(t *testing.T) {
 				}
 			}
 		})
+		t.Run("directory tree content", func(t *testing.T) {
+			agentServer := kubejob.NewAgentServer(startAllocationPort)
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			done := make(chan struct{})
+
+			go func() {
+				if err := agentServer.Run(ctx); err != nil {
+					t.Error(err)
+				}
+				done <- struct{}{}
+			}()
+
+			srcDir := createTemporaryDirectory(t)
+			defer os.RemoveAll(srcDir)
+
+			dstDir, err := os.MkdirTemp("", "repo2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dstDir)
+
+			agentClient, err := kubejob.NewAgentClient(
+				&corev1.Pod{Status: corev1.PodStatus{PodIP: "127.0.0.1"}},
+				startAllocationPort,
+				"",
+				signedToken,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := agentClient.CopyFrom(ctx, srcDir, dstDir); err != nil {
+				t.Fatal(err)
+			}
+			if err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					return nil
+				}
+				rel, err := filepath.Rel(filepath.Dir(srcDir), path)
+				if err != nil {
+					return err
+				}
+				expected, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				got, err := os.ReadFile(filepath.Join(dstDir, rel))
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(expected, got) {
+					return fmt.Errorf("mismatch content of %s", rel)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(fmt.Sprintf("%s.tar", srcDir)); err == nil {
+				t.Fatal("expected to remove the archived file")
+			}
+			if err := agentClient.Stop(ctx); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-done:
+			case <-ctx.Done():
+				if err := ctx.Err(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+		t.Run("not exist source path", func(t *testing.T) {
+			agentServer := kubejob.NewAgentServer(startAllocationPort)
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			done := make(chan struct{})
+
+			go func() {
+				if err := agentServer.Run(ctx); err != nil {
+					t.Error(err)
+				}
+				done <- struct{}{}
+			}()
+
+			dstDir, err := os.MkdirTemp("", "repo2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dstDir)
+
+			agentClient, err := kubejob.NewAgentClient(
+				&corev1.Pod{Status: corev1.PodStatus{PodIP: "127.0.0.1"}},
+				startAllocationPort,
+				"",
+				signedToken,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := agentClient.CopyFrom(ctx, filepath.Join(dstDir, "not-exist"), filepath.Join(dstDir, "dst")); err == nil {
+				t.Fatal("expected error")
+			}
+			if err := agentClient.Stop(ctx); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-done:
+			case <-ctx.Done():
+				if err := ctx.Err(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
 	})
 
 	t.Run("copyTo", func(t *testing.T) {
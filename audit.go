@@ -0,0 +1,68 @@
+package kubejob
+
+import (
+	"time"
+)
+
+type AuditVerb string
+
+const (
+	AuditVerbExec AuditVerb = "exec"
+	AuditVerbCopy AuditVerb = "copy"
+)
+
+// AuditEntry is the record of the operation executed in the pod by kubejob.
+type AuditEntry struct {
+	Verb      AuditVerb
+	Pod       string
+	Container string
+	// Command is the executed command when Verb is AuditVerbExec.
+	Command []string
+	// SrcPath and DstPath are the paths of copy operation when Verb is AuditVerbCopy.
+	// The path on the pod is prefixed with `pod:`.
+	SrcPath   string
+	DstPath   string
+	Timestamp time.Time
+}
+
+type AuditHandler func(AuditEntry)
+
+// SetAuditHandler set the handler called on each exec and copy operation in the pod.
+// This is independent of the logger, so it can be used to record the audit trail.
+func (j *Job) SetAuditHandler(handler AuditHandler) {
+	j.auditHandler = handler
+}
+
+func (e *JobExecutor) auditExec(cmd []string) {
+	if e.job.auditHandler == nil {
+		return
+	}
+	e.job.auditHandler(AuditEntry{
+		Verb:      AuditVerbExec,
+		Pod:       e.podName(),
+		Container: e.Container.Name,
+		Command:   append([]string{}, cmd...),
+		Timestamp: time.Now(),
+	})
+}
+
+func (e *JobExecutor) auditCopy(srcPath, dstPath string) {
+	if e.job.auditHandler == nil {
+		return
+	}
+	e.job.auditHandler(AuditEntry{
+		Verb:      AuditVerbCopy,
+		Pod:       e.podName(),
+		Container: e.Container.Name,
+		SrcPath:   srcPath,
+		DstPath:   dstPath,
+		Timestamp: time.Now(),
+	})
+}
+
+func (e *JobExecutor) podName() string {
+	if e.Pod == nil {
+		return ""
+	}
+	return e.Pod.Name
+}
@@ -0,0 +1,50 @@
+package kubejob
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// RunResult is the result of the Job run by RunAll.
+type RunResult struct {
+	Job *Job
+	Err error
+}
+
+// RunAll runs the jobs in parallel up to the concurrency and returns the result of each job in the same order as jobs.
+// The failure of a job doesn't abort the other jobs, so check the Err of each RunResult.
+// If the context is canceled, the jobs that have not been started yet are not run and their Err is the context error.
+func RunAll(ctx context.Context, jobs []*Job, concurrency int) ([]RunResult, error) {
+	if concurrency <= 0 {
+		return nil, fmt.Errorf("job: invalid concurrency %d. concurrency must be greater than 0", concurrency)
+	}
+	results := make([]RunResult, len(jobs))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for idx, job := range jobs {
+		results[idx] = RunResult{Job: job}
+		select {
+		case <-ctx.Done():
+			results[idx].Err = ctx.Err()
+			continue
+		case sem <- struct{}{}:
+		}
+		// select picks randomly if both cases are ready, so check the cancellation again before starting the job.
+		if err := ctx.Err(); err != nil {
+			<-sem
+			results[idx].Err = err
+			continue
+		}
+		wg.Add(1)
+		go func(result *RunResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result.Err = result.Job.Run(ctx)
+		}(&results[idx])
+	}
+	wg.Wait()
+	return results, nil
+}
@@ -1,23 +1,92 @@
 package kubejob
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/rs/xid"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
 type JobBuilder struct {
-	config    *rest.Config
-	namespace string
-	image     string
-	command   []string
+	config                    *rest.Config
+	namespace                 string
+	image                     string
+	command                   []string
+	workingDir                string
+	readinessProbe            *corev1.Probe
+	livenessProbe             *corev1.Probe
+	startupProbe              *corev1.Probe
+	initContainers            []corev1.Container
+	spreadAcrossNodes         bool
+	autoLabel                 bool
+	generateName              string
+	podSecurityContext        *corev1.PodSecurityContext
+	fsGroup                   *int64
+	containerSecurityContext  *corev1.SecurityContext
+	suspend                   *bool
+	completionMode            *batchv1.CompletionMode
+	activeDeadlineSeconds     *int64
+	disableRestartPolicy      bool
+	priorityClassName         string
+	schedulerName             string
+	annotations               map[string]string
+	logParser                 string
+	stdinFile                 string
+	sidecarShutdown           bool
+	mutators                  []func(*batchv1.Job) error
+	qps                       *float32
+	burst                     *int
+	dnsConfig                 *corev1.PodDNSConfig
+	hostNetwork               bool
+	hostAliases               []corev1.HostAlias
+	topologySpreadConstraints []corev1.TopologySpreadConstraint
+	volumes                   []corev1.Volume
+	volumeMounts              []containerVolumeMount
+}
+
+type containerVolumeMount struct {
+	mount          corev1.VolumeMount
+	containerNames []string
+}
+
+var serviceAccountNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+const defaultNamespace = "default"
+
+// NewJobBuilderInNamespace creates JobBuilder with the namespace of the service account mounted to the running pod.
+// If the namespace cannot be detected ( e.g. running outside of the cluster ), "default" is used.
+func NewJobBuilderInNamespace(config *rest.Config) *JobBuilder {
+	return NewJobBuilder(config, detectNamespace())
+}
+
+func detectNamespace() string {
+	b, err := os.ReadFile(serviceAccountNamespacePath)
+	if err != nil {
+		return defaultNamespace
+	}
+	namespace := strings.TrimSpace(string(b))
+	if namespace == "" {
+		return defaultNamespace
+	}
+	return namespace
 }
 
 func NewJobBuilder(config *rest.Config, namespace string) *JobBuilder {
@@ -41,6 +110,476 @@ func (b *JobBuilder) SetCommand(cmd []string) *JobBuilder {
 	return b
 }
 
+// SetGenerateName set the prefix of the Job name. The name is assigned by the API server when the Job is created.
+// By default, "kubejob-" is used.
+func (b *JobBuilder) SetGenerateName(prefix string) *JobBuilder {
+	b.generateName = prefix
+	return b
+}
+
+// SetWorkingDir set the working directory of the container.
+func (b *JobBuilder) SetWorkingDir(dir string) *JobBuilder {
+	b.workingDir = dir
+	return b
+}
+
+// SetReadinessProbe set the readiness probe of the container.
+func (b *JobBuilder) SetReadinessProbe(probe *corev1.Probe) *JobBuilder {
+	b.readinessProbe = probe
+	return b
+}
+
+// SetLivenessProbe set the liveness probe of the container.
+func (b *JobBuilder) SetLivenessProbe(probe *corev1.Probe) *JobBuilder {
+	b.livenessProbe = probe
+	return b
+}
+
+// SetStartupProbe set the startup probe of the container.
+func (b *JobBuilder) SetStartupProbe(probe *corev1.Probe) *JobBuilder {
+	b.startupProbe = probe
+	return b
+}
+
+// AddInitContainer adds the init container executed before the main container.
+// The init containers are executed in the order of addition.
+func (b *JobBuilder) AddInitContainer(c corev1.Container) *JobBuilder {
+	b.initContainers = append(b.initContainers, c)
+	return b
+}
+
+func (b *JobBuilder) SetPodSecurityContext(sc *corev1.PodSecurityContext) *JobBuilder {
+	b.podSecurityContext = sc
+	return b
+}
+
+func (b *JobBuilder) SetContainerSecurityContext(sc *corev1.SecurityContext) *JobBuilder {
+	b.containerSecurityContext = sc
+	return b
+}
+
+// RunAsNonRoot runs all containers in the pod with the specified uid and gid.
+func (b *JobBuilder) RunAsNonRoot(uid, gid int64) *JobBuilder {
+	if b.podSecurityContext == nil {
+		b.podSecurityContext = &corev1.PodSecurityContext{}
+	}
+	runAsNonRoot := true
+	b.podSecurityContext.RunAsNonRoot = &runAsNonRoot
+	b.podSecurityContext.RunAsUser = &uid
+	b.podSecurityContext.RunAsGroup = &gid
+	return b
+}
+
+// SetFSGroup set the supplemental group applied to all containers in the pod.
+// The volumes ( e.g. added by AddSharedVolume ) are owned by the group, so they are writable across the non-root containers.
+func (b *JobBuilder) SetFSGroup(gid int64) *JobBuilder {
+	b.fsGroup = &gid
+	return b
+}
+
+// ReadOnlyRootFilesystem mounts the root filesystem of the container as read-only.
+func (b *JobBuilder) ReadOnlyRootFilesystem() *JobBuilder {
+	if b.containerSecurityContext == nil {
+		b.containerSecurityContext = &corev1.SecurityContext{}
+	}
+	readOnly := true
+	b.containerSecurityContext.ReadOnlyRootFilesystem = &readOnly
+	return b
+}
+
+// SetSuspended creates the Job in the suspended state. No pods are started until `(*Job).Resume` is called.
+func (b *JobBuilder) SetSuspended(suspend bool) *JobBuilder {
+	b.suspend = &suspend
+	return b
+}
+
+// DisableRestartPolicyDefault preserves the empty restart policy of the pod.
+// By default, RestartPolicyNever is set if the restart policy is empty.
+// Note that the Job is rejected by the API server if the restart policy is not set by others ( e.g. mutating webhook ),
+// because the restart policy of the Job must be Never or OnFailure.
+func (b *JobBuilder) DisableRestartPolicyDefault() *JobBuilder {
+	b.disableRestartPolicy = true
+	return b
+}
+
+// AutoLabel adds the labels derived from the image ( ImageLabel ) and the command ( CommandHashLabel ) to the pod.
+// This is useful to group the jobs on the dashboard.
+// The image is sanitized to the valid label value, and the command is hashed because it may contain any characters.
+func (b *JobBuilder) AutoLabel(enabled bool) *JobBuilder {
+	b.autoLabel = enabled
+	return b
+}
+
+func (b *JobBuilder) addAutoLabels(template *corev1.PodTemplateSpec) {
+	containers := template.Spec.Containers
+	if len(containers) == 0 {
+		return
+	}
+	hash := sha256.New()
+	for _, c := range containers {
+		fmt.Fprintf(hash, "%s\x00%s\x00", strings.Join(c.Command, " "), strings.Join(c.Args, " "))
+	}
+	template.Labels[ImageLabel] = sanitizeLabelValue(containers[0].Image)
+	template.Labels[CommandHashLabel] = hex.EncodeToString(hash.Sum(nil))[:commandHashLength]
+}
+
+// sanitizeLabelValue replaces the invalid characters of the label value with '-' and truncates it.
+func sanitizeLabelValue(v string) string {
+	sanitized := []rune(v)
+	for idx, r := range sanitized {
+		isAlnum := ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')
+		if !isAlnum && r != '-' && r != '_' && r != '.' {
+			sanitized[idx] = '-'
+		}
+	}
+	value := string(sanitized)
+	if len(value) > validation.LabelValueMaxLength {
+		value = value[:validation.LabelValueMaxLength]
+	}
+	// the label value must begin and end with an alphanumeric character.
+	return strings.Trim(value, "-_.")
+}
+
+// SetActiveDeadline set the duration the Job may be active before the system tries to terminate it.
+// If the deadline is exceeded, Run returns *DeadlineExceededError. The duration is rounded up to seconds.
+func (b *JobBuilder) SetActiveDeadline(d time.Duration) *JobBuilder {
+	sec := int64((d + time.Second - 1) / time.Second)
+	b.activeDeadlineSeconds = &sec
+	return b
+}
+
+// SetCompletionMode set the completion mode of the Job.
+// If batchv1.IndexedCompletion is specified, each pod has the completion index
+// and it's printed as the prefix of the container log.
+func (b *JobBuilder) SetCompletionMode(mode batchv1.CompletionMode) *JobBuilder {
+	b.completionMode = &mode
+	return b
+}
+
+// SetPriorityClassName set the priority class of the pod.
+// The priority class is validated whether it exists when the Job runs.
+func (b *JobBuilder) SetPriorityClassName(name string) *JobBuilder {
+	b.priorityClassName = name
+	return b
+}
+
+// SetSchedulerName set the scheduler name of the pod to use the custom scheduler ( e.g. Volcano, YuniKorn ).
+func (b *JobBuilder) SetSchedulerName(name string) *JobBuilder {
+	b.schedulerName = name
+	return b
+}
+
+// SetAnnotations set the annotations to the pod ( e.g. sidecar injection of service mesh ).
+func (b *JobBuilder) SetAnnotations(annotations map[string]string) *JobBuilder {
+	b.annotations = annotations
+	return b
+}
+
+// SetLogParserAnnotation set the annotation to the pod to tell the cluster log collector ( Fluent Bit ) the parser of the container logs.
+// If the annotation is also specified by SetAnnotations, it takes precedence over this.
+func (b *JobBuilder) SetLogParserAnnotation(parser string) *JobBuilder {
+	b.logParser = parser
+	return b
+}
+
+// SetSidecarShutdown shutdowns the sidecar containers ( e.g. istio-proxy or linkerd-proxy ) after the main containers are finished.
+// Without this, the pod never completes because the sidecar keeps running.
+// The containers that are not specified by the Job are regarded as sidecars.
+func (b *JobBuilder) SetSidecarShutdown(enabled bool) *JobBuilder {
+	b.sidecarShutdown = enabled
+	return b
+}
+
+// AddMutator adds the function to modify the final Job spec.
+// The mutators are called in the order of addition after the defaults are applied.
+// If the mutator returns an error, the build is aborted.
+func (b *JobBuilder) AddMutator(mutator func(*batchv1.Job) error) *JobBuilder {
+	b.mutators = append(b.mutators, mutator)
+	return b
+}
+
+// SpreadAcrossNodes adds the preferred pod anti-affinity so that the pods of the Job are scheduled onto different nodes.
+func (b *JobBuilder) SpreadAcrossNodes() *JobBuilder {
+	b.spreadAcrossNodes = true
+	return b
+}
+
+// SetQPS set the maximum QPS to the API server from the client.
+// This is useful to avoid the client-side throttling when many commands are executed.
+func (b *JobBuilder) SetQPS(qps float32) *JobBuilder {
+	b.qps = &qps
+	return b
+}
+
+// SetBurst set the maximum burst for the client-side throttle.
+func (b *JobBuilder) SetBurst(burst int) *JobBuilder {
+	b.burst = &burst
+	return b
+}
+
+func (b *JobBuilder) SetDNSConfig(dnsConfig *corev1.PodDNSConfig) *JobBuilder {
+	b.dnsConfig = dnsConfig
+	return b
+}
+
+// SetHostNetwork set whether the pod uses the host's network namespace.
+// If enabled and the dnsPolicy is ClusterFirst or empty, the dnsPolicy is set to ClusterFirstWithHostNet
+// so that the pod can still resolve the cluster services.
+func (b *JobBuilder) SetHostNetwork(enabled bool) *JobBuilder {
+	b.hostNetwork = enabled
+	return b
+}
+
+// AddHostAlias adds the entry to /etc/hosts of the pod.
+func (b *JobBuilder) AddHostAlias(ip string, hostnames ...string) *JobBuilder {
+	b.hostAliases = append(b.hostAliases, corev1.HostAlias{
+		IP:        ip,
+		Hostnames: hostnames,
+	})
+	return b
+}
+
+// AddSharedVolume adds the emptyDir volume shared by the containers and mounts it to mountPath.
+// If subPath is specified, mounts the subdirectory of the volume instead of its root.
+// If containerNames are specified, mounts it only to those containers ( including init containers ). Otherwise mounts it to all containers.
+// Call it multiple times with the same name to mount the distinct subdirectory of the same volume to each container.
+func (b *JobBuilder) AddSharedVolume(name, mountPath, subPath string, containerNames ...string) *JobBuilder {
+	b.addVolume(corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	b.volumeMounts = append(b.volumeMounts, containerVolumeMount{
+		mount: corev1.VolumeMount{
+			Name:      name,
+			MountPath: mountPath,
+			SubPath:   subPath,
+		},
+		containerNames: containerNames,
+	})
+	return b
+}
+
+// MountSecretSubPath mounts the key ( subPath ) of the secret to mountPath as read only.
+// If subPath is empty, mounts all keys of the secret as the directory.
+// If containerNames are specified, mounts it only to those containers ( including init containers ). Otherwise mounts it to all containers.
+func (b *JobBuilder) MountSecretSubPath(secretName, mountPath, subPath string, containerNames ...string) *JobBuilder {
+	name := fmt.Sprintf("secret-%s", secretName)
+	b.addVolume(corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	})
+	b.volumeMounts = append(b.volumeMounts, containerVolumeMount{
+		mount: corev1.VolumeMount{
+			Name:      name,
+			MountPath: mountPath,
+			SubPath:   subPath,
+			ReadOnly:  true,
+		},
+		containerNames: containerNames,
+	})
+	return b
+}
+
+// MountPVC mounts the PersistentVolumeClaim to mountPath of all containers ( including init containers ).
+func (b *JobBuilder) MountPVC(claimName, mountPath string, readOnly bool) *JobBuilder {
+	name := pvcVolumeName(claimName)
+	b.addVolume(corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: claimName,
+				ReadOnly:  readOnly,
+			},
+		},
+	})
+	b.volumeMounts = append(b.volumeMounts, containerVolumeMount{
+		mount: corev1.VolumeMount{
+			Name:      name,
+			MountPath: mountPath,
+			ReadOnly:  readOnly,
+		},
+	})
+	return b
+}
+
+// pvcVolumeName returns the volume name for the claim.
+// The claim name may contain dots or be longer than the volume name allows ( DNS-1123 label ),
+// so it is sanitized and truncated with the hash suffix to keep the name unique in that case.
+func pvcVolumeName(claimName string) string {
+	name := fmt.Sprintf("pvc-%s", claimName)
+	if len(validation.IsDNS1123Label(name)) == 0 {
+		return name
+	}
+	hash := sha256.Sum256([]byte(claimName))
+	suffix := hex.EncodeToString(hash[:])[:commandHashLength]
+	name = strings.ToLower(strings.ReplaceAll(name, ".", "-"))
+	if maxLen := validation.DNS1123LabelMaxLength - len(suffix) - 1; len(name) > maxLen {
+		name = name[:maxLen]
+	}
+	return fmt.Sprintf("%s-%s", strings.TrimRight(name, "-"), suffix)
+}
+
+func (b *JobBuilder) addVolume(volume corev1.Volume) {
+	for _, v := range b.volumes {
+		if v.Name == volume.Name {
+			return
+		}
+	}
+	b.volumes = append(b.volumes, volume)
+}
+
+func (b *JobBuilder) applyVolumes(spec *corev1.PodSpec) {
+	for _, volume := range b.volumes {
+		exists := false
+		for _, v := range spec.Volumes {
+			if v.Name == volume.Name {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			spec.Volumes = append(spec.Volumes, volume)
+		}
+	}
+	if len(b.volumeMounts) == 0 {
+		return
+	}
+	// copy the containers not to modify the containers held by the builder ( e.g. added by AddInitContainer ).
+	apply := func(containers []corev1.Container) []corev1.Container {
+		if containers == nil {
+			return nil
+		}
+		copied := make([]corev1.Container, 0, len(containers))
+		for _, container := range containers {
+			mounts := append([]corev1.VolumeMount{}, container.VolumeMounts...)
+			for _, m := range b.volumeMounts {
+				if m.isTarget(container.Name) {
+					mounts = append(mounts, m.mount)
+				}
+			}
+			container.VolumeMounts = mounts
+			copied = append(copied, container)
+		}
+		return copied
+	}
+	spec.InitContainers = apply(spec.InitContainers)
+	spec.Containers = apply(spec.Containers)
+}
+
+func (m containerVolumeMount) isTarget(containerName string) bool {
+	if len(m.containerNames) == 0 {
+		return true
+	}
+	for _, name := range m.containerNames {
+		if name == containerName {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	stdinVolumeName        = "kubejob-stdin"
+	stdinInitContainerName = "kubejob-stdin"
+	stdinMountPath         = "/kubejob/stdin"
+	stdinPath              = stdinMountPath + "/stdin"
+)
+
+// SetStdinFile set the local file as the stdin of the main container ( the first container ).
+// The file is copied to the shared volume by the init container before the main container starts ( see PreInit ),
+// and the command of the main container is wrapped by `sh` to redirect its stdin from the copied file.
+// Since the file is copied by `(*JobExecutor).CopyToPod`, the image of the main container must have `tar` command.
+// If PreInit is also used, the file is copied by its container before the callback is called.
+func (b *JobBuilder) SetStdinFile(localPath string) *JobBuilder {
+	b.stdinFile = localPath
+	return b
+}
+
+// applyStdinFile adds the volume to copy the stdin file and redirects the stdin of the main container from it.
+// The init container to copy the file is added at running the Job.
+func (b *JobBuilder) applyStdinFile(spec *corev1.PodSpec) error {
+	if b.stdinFile == "" || len(spec.Containers) == 0 {
+		return nil
+	}
+	info, err := os.Stat(b.stdinFile)
+	if err != nil {
+		return fmt.Errorf("job: failed to read stdin file %s: %w", b.stdinFile, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("job: failed to read stdin file %s: it is a directory", b.stdinFile)
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: stdinVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	main := spec.Containers[0]
+	// $0 is the first element of the original command.
+	main.Args = append(append([]string{}, main.Command...), main.Args...)
+	main.Command = []string{"sh", "-c", fmt.Sprintf(`exec "$0" "$@" < %s`, stdinPath)}
+	main.VolumeMounts = append(append([]corev1.VolumeMount{}, main.VolumeMounts...), stdinVolumeMount())
+	spec.Containers = append([]corev1.Container{main}, spec.Containers[1:]...)
+	return nil
+}
+
+func stdinVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      stdinVolumeName,
+		MountPath: stdinMountPath,
+	}
+}
+
+// AddTopologySpreadConstraint adds the constraint to spread the pods across the topology domains ( e.g. zones or nodes ).
+func (b *JobBuilder) AddTopologySpreadConstraint(constraint corev1.TopologySpreadConstraint) *JobBuilder {
+	b.topologySpreadConstraints = append(b.topologySpreadConstraints, constraint)
+	return b
+}
+
+func (b *JobBuilder) addSpreadAcrossNodesAffinity(template *corev1.PodTemplateSpec) {
+	if template.Spec.Affinity == nil {
+		template.Spec.Affinity = &corev1.Affinity{}
+	}
+	if template.Spec.Affinity.PodAntiAffinity == nil {
+		template.Spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{}
+	}
+	antiAffinity := template.Spec.Affinity.PodAntiAffinity
+	antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+		antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+		corev1.WeightedPodAffinityTerm{
+			Weight: 100,
+			PodAffinityTerm: corev1.PodAffinityTerm{
+				LabelSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						SelectorLabel: template.Labels[SelectorLabel],
+					},
+				},
+				TopologyKey: corev1.LabelHostname,
+			},
+		},
+	)
+}
+
+func (b *JobBuilder) restConfig() *rest.Config {
+	if b.qps == nil && b.burst == nil {
+		return b.config
+	}
+	config := rest.CopyConfig(b.config)
+	if b.qps != nil {
+		config.QPS = *b.qps
+	}
+	if b.burst != nil {
+		config.Burst = *b.burst
+	}
+	return config
+}
+
 func (b *JobBuilder) Build() (*Job, error) {
 	if b.image == "" {
 		return nil, errRequiredParam("container.image")
@@ -48,21 +587,31 @@ func (b *JobBuilder) Build() (*Job, error) {
 	if len(b.command) == 0 {
 		return nil, errRequiredParam("container.command")
 	}
+	generateName := DefaultJobName
+	if b.generateName != "" {
+		generateName = b.generateName
+	}
 	return b.BuildWithJob(&batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: DefaultJobName,
+			GenerateName: generateName,
 		},
 		Spec: batchv1.JobSpec{
 			Template: corev1.PodTemplateSpec{
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
 						{
-							Name:    DefaultContainerName,
-							Image:   b.image,
-							Command: b.command,
+							Name:            DefaultContainerName,
+							Image:           b.image,
+							Command:         b.command,
+							WorkingDir:      b.workingDir,
+							ReadinessProbe:  b.readinessProbe,
+							LivenessProbe:   b.livenessProbe,
+							StartupProbe:    b.startupProbe,
+							SecurityContext: b.containerSecurityContext,
 						},
 					},
-					RestartPolicy: corev1.RestartPolicyNever,
+					InitContainers:  b.initContainers,
+					SecurityContext: b.podSecurityContext,
 				},
 			},
 			BackoffLimit: new(int32),
@@ -70,6 +619,30 @@ func (b *JobBuilder) Build() (*Job, error) {
 	})
 }
 
+// RunOutput builds the single container job, runs it and returns the trimmed log of the container.
+// The created job is removed after running.
+// If the job fails, returns the log collected until then with the error.
+func (b *JobBuilder) RunOutput(ctx context.Context) (string, error) {
+	job, err := b.Build()
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	job.DisableInitContainerLog()
+	job.DisableInitCommandLog()
+	job.DisableCommandLog()
+	job.SetContainerLogger(func(log *ContainerLog) {
+		if log.IsFinished {
+			return
+		}
+		out.WriteString(log.Log)
+	})
+	if err := job.Run(ctx); err != nil {
+		return strings.TrimSpace(out.String()), err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
 func (b *JobBuilder) BuildWithReader(r io.Reader) (*Job, error) {
 	var jobSpec batchv1.Job
 	if err := yaml.NewYAMLOrJSONDecoder(r, 1024).Decode(&jobSpec); err != nil {
@@ -78,23 +651,139 @@ func (b *JobBuilder) BuildWithReader(r io.Reader) (*Job, error) {
 	return b.BuildWithJob(&jobSpec)
 }
 
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// BuildWithReaderEnv builds the Job like BuildWithReader, but substitutes ${VAR} in the manifest by vars before decoding.
+// The default value can be specified by ${VAR:-default}. If the variable is not defined and has no default value, returns *UndefinedVariableError.
+func (b *JobBuilder) BuildWithReaderEnv(r io.Reader, vars map[string]string) (*Job, error) {
+	manifest, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errInvalidYAML(err)
+	}
+	var undefined []string
+	rendered := envVarPattern.ReplaceAllFunc(manifest, func(match []byte) []byte {
+		group := envVarPattern.FindSubmatch(match)
+		name := string(group[1])
+		if v, exists := vars[name]; exists {
+			return []byte(v)
+		}
+		if len(group[2]) > 0 {
+			return group[3]
+		}
+		undefined = append(undefined, name)
+		return match
+	})
+	if len(undefined) > 0 {
+		return nil, errUndefinedVariable(undefined)
+	}
+	return b.BuildWithReader(bytes.NewReader(rendered))
+}
+
+// validateLabels validates labels by the same rules as the label selector.
+// If the invalid label is used, the watch for the pod matches nothing and the job hangs.
+func (b *JobBuilder) validateLabels(labels map[string]string) error {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := labels[k]
+		errs := validation.IsQualifiedName(k)
+		errs = append(errs, validation.IsValidLabelValue(v)...)
+		if len(errs) > 0 {
+			return errInvalidLabel(k, v, errs)
+		}
+	}
+	return nil
+}
+
+// BuildFromFile builds the Job from the file written in YAML or JSON.
+// The file that has .json extension is decoded as JSON, otherwise the format is detected by the content like BuildWithReader.
+func (b *JobBuilder) BuildFromFile(path string) (*Job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("job: failed to open job file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var jobSpec batchv1.Job
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		if err := json.NewDecoder(f).Decode(&jobSpec); err != nil {
+			return nil, errInvalidYAML(err)
+		}
+	default:
+		// the file may not have the extension ( e.g. process substitution ), so detect the format by the content.
+		if err := yaml.NewYAMLOrJSONDecoder(f, 1024).Decode(&jobSpec); err != nil {
+			return nil, errInvalidYAML(err)
+		}
+	}
+	return b.BuildWithJob(&jobSpec)
+}
+
 func (b *JobBuilder) BuildWithJob(jobSpec *batchv1.Job) (*Job, error) {
-	clientset, err := kubernetes.NewForConfig(b.config)
+	config := b.restConfig()
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("job: failed to create clientset: %w", err)
 	}
 	jobClient := clientset.BatchV1().Jobs(b.namespace)
 	podClient := clientset.CoreV1().Pods(b.namespace)
+	configMapClient := clientset.CoreV1().ConfigMaps(b.namespace)
+	secretClient := clientset.CoreV1().Secrets(b.namespace)
+	priorityClassClient := clientset.SchedulingV1().PriorityClasses()
 	restClient := clientset.CoreV1().RESTClient()
 	if jobSpec.ObjectMeta.Name == "" && jobSpec.ObjectMeta.GenerateName == "" {
 		return nil, errRequiredParam("job.name")
 	}
-	if jobSpec.Spec.Template.Spec.RestartPolicy == "" {
+	if jobSpec.Spec.Template.Spec.RestartPolicy == "" && !b.disableRestartPolicy {
 		jobSpec.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
 	}
 	if jobSpec.Spec.BackoffLimit == nil {
 		jobSpec.Spec.BackoffLimit = new(int32)
 	}
+	if b.suspend != nil {
+		jobSpec.Spec.Suspend = b.suspend
+	}
+	if b.completionMode != nil {
+		jobSpec.Spec.CompletionMode = b.completionMode
+	}
+	if b.activeDeadlineSeconds != nil {
+		jobSpec.Spec.ActiveDeadlineSeconds = b.activeDeadlineSeconds
+	}
+	if b.priorityClassName != "" {
+		jobSpec.Spec.Template.Spec.PriorityClassName = b.priorityClassName
+	}
+	if b.schedulerName != "" {
+		jobSpec.Spec.Template.Spec.SchedulerName = b.schedulerName
+	}
+	if b.dnsConfig != nil {
+		jobSpec.Spec.Template.Spec.DNSConfig = b.dnsConfig
+	}
+	if b.hostNetwork {
+		jobSpec.Spec.Template.Spec.HostNetwork = true
+		switch jobSpec.Spec.Template.Spec.DNSPolicy {
+		case "", corev1.DNSClusterFirst:
+			jobSpec.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		}
+	}
+	jobSpec.Spec.Template.Spec.HostAliases = append(jobSpec.Spec.Template.Spec.HostAliases, b.hostAliases...)
+	jobSpec.Spec.Template.Spec.TopologySpreadConstraints = append(
+		jobSpec.Spec.Template.Spec.TopologySpreadConstraints,
+		b.topologySpreadConstraints...,
+	)
+	if b.fsGroup != nil {
+		sc := &corev1.PodSecurityContext{}
+		if jobSpec.Spec.Template.Spec.SecurityContext != nil {
+			// copy not to modify the security context held by the builder.
+			sc = jobSpec.Spec.Template.Spec.SecurityContext.DeepCopy()
+		}
+		fsGroup := *b.fsGroup
+		sc.FSGroup = &fsGroup
+		jobSpec.Spec.Template.Spec.SecurityContext = sc
+	}
+	b.applyVolumes(&jobSpec.Spec.Template.Spec)
 	for idx := range jobSpec.Spec.Template.Spec.Containers {
 		if jobSpec.Spec.Template.Spec.Containers[idx].Name == "" {
 			return nil, errRequiredParam("container.name")
@@ -106,16 +795,52 @@ func (b *JobBuilder) BuildWithJob(jobSpec *batchv1.Job) (*Job, error) {
 			return nil, errRequiredParam("container.image")
 		}
 	}
+	if err := b.applyStdinFile(&jobSpec.Spec.Template.Spec); err != nil {
+		return nil, err
+	}
+	if len(b.annotations) > 0 {
+		if jobSpec.Spec.Template.Annotations == nil {
+			jobSpec.Spec.Template.Annotations = map[string]string{}
+		}
+		for k, v := range b.annotations {
+			jobSpec.Spec.Template.Annotations[k] = v
+		}
+	}
+	if _, exists := b.annotations[FluentBitParserAnnotation]; b.logParser != "" && !exists {
+		if jobSpec.Spec.Template.Annotations == nil {
+			jobSpec.Spec.Template.Annotations = map[string]string{}
+		}
+		jobSpec.Spec.Template.Annotations[FluentBitParserAnnotation] = b.logParser
+	}
 	if jobSpec.Spec.Template.Labels == nil {
 		jobSpec.Spec.Template.Labels = map[string]string{}
 	}
 	jobSpec.Spec.Template.Labels[SelectorLabel] = b.labelID()
+	if b.spreadAcrossNodes {
+		b.addSpreadAcrossNodesAffinity(&jobSpec.Spec.Template)
+	}
+	if b.autoLabel {
+		b.addAutoLabels(&jobSpec.Spec.Template)
+	}
+	for _, mutator := range b.mutators {
+		if err := mutator(jobSpec); err != nil {
+			return nil, fmt.Errorf("job: failed to mutate job: %w", err)
+		}
+	}
+	if err := b.validateLabels(jobSpec.Spec.Template.Labels); err != nil {
+		return nil, err
+	}
 
 	return &Job{
-		Job:        jobSpec,
-		jobClient:  jobClient,
-		podClient:  podClient,
-		restClient: restClient,
-		config:     b.config,
+		Job:                 jobSpec,
+		jobClient:           jobClient,
+		podClient:           podClient,
+		configMapClient:     configMapClient,
+		secretClient:        secretClient,
+		priorityClassClient: priorityClassClient,
+		restClient:          restClient,
+		config:              config,
+		sidecarShutdown:     b.sidecarShutdown,
+		stdinFile:           b.stdinFile,
 	}, nil
 }
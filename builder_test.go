@@ -0,0 +1,53 @@
+package kubejob_test
+
+import (
+	"testing"
+
+	"github.com/goccy/kubejob"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+func Test_JobBuilderPodSpec(t *testing.T) {
+	tests := []struct {
+		name   string
+		build  func(*kubejob.JobBuilder) *kubejob.JobBuilder
+		verify func(*testing.T, apiv1.PodSpec)
+	}{
+		{
+			name: "scheduler name",
+			build: func(b *kubejob.JobBuilder) *kubejob.JobBuilder {
+				return b.SetSchedulerName("volcano")
+			},
+			verify: func(t *testing.T, spec apiv1.PodSpec) {
+				if spec.SchedulerName != "volcano" {
+					t.Fatalf("unexpected scheduler name: %q", spec.SchedulerName)
+				}
+			},
+		},
+		{
+			name: "default scheduler",
+			build: func(b *kubejob.JobBuilder) *kubejob.JobBuilder {
+				return b
+			},
+			verify: func(t *testing.T, spec apiv1.PodSpec) {
+				if spec.SchedulerName != "" {
+					t.Fatalf("unexpected scheduler name: %q", spec.SchedulerName)
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			builder := kubejob.NewJobBuilder(&rest.Config{}, "default").
+				SetImage(goImageName).
+				SetCommand([]string{"echo", "hello"})
+			job, err := test.build(builder).Build()
+			if err != nil {
+				t.Fatalf("failed to build job: %+v", err)
+			}
+			test.verify(t, job.Spec.Template.Spec)
+		})
+	}
+}
@@ -68,11 +68,7 @@ func _main(args []string, opt option) error {
 	}
 	var job *kubejob.Job
 	if opt.File != "" {
-		file, err := os.Open(opt.File)
-		if err != nil {
-			return fmt.Errorf("failed to open file %s: %w", opt.File, err)
-		}
-		j, err := kubejob.NewJobBuilder(cfg, ns).BuildWithReader(file)
+		j, err := kubejob.NewJobBuilder(cfg, ns).BuildFromFile(opt.File)
 		if err != nil {
 			return err
 		}
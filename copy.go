@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -30,6 +31,7 @@ func (e *JobExecutor) CopyToPod(srcPath, dstPath string) error {
 	if _, err := os.Stat(srcPath); err != nil {
 		return errCopy(srcPath, dstPath, fmt.Errorf("%s doesn't exist in local filesystem", srcPath))
 	}
+	e.auditCopy(srcPath, fmt.Sprintf("pod:%s", dstPath))
 	if e.EnabledAgent() {
 		return e.agentClient.CopyTo(context.Background(), srcPath, dstPath)
 	}
@@ -50,6 +52,74 @@ func (e *JobExecutor) CopyToPod(srcPath, dstPath string) error {
 		tarCmd = append(tarCmd, "-C", dstDir)
 	}
 
+	return e.copyToPodWithTar(tarCmd, srcPath, dstPath, func(w io.Writer) error {
+		return e.writeWithTar(w, srcPath, dstPath)
+	})
+}
+
+// CopyFilesToPod copy multiple files or directories to the specified paths on Pod at once.
+// files is a map of local path to destination path on Pod, and every destination path must be absolute path.
+// All files are packed into one tar stream and extracted by a single exec, so it reduces the latency of copying scattered files.
+func (e *JobExecutor) CopyFilesToPod(files map[string]string) error {
+	if e.stopped {
+		return fmt.Errorf("job: failed to copy to pod. pod is already stopped")
+	}
+	srcPaths := make([]string, 0, len(files))
+	for srcPath, dstPath := range files {
+		if len(srcPath) == 0 || len(dstPath) == 0 {
+			return errCopyWithEmptyPath(srcPath, dstPath)
+		}
+		if !path.IsAbs(dstPath) {
+			// all files are extracted at root directory, so a relative path cannot be resolved against the working directory.
+			return errCopy(srcPath, dstPath, fmt.Errorf("destination path must be absolute path"))
+		}
+		if _, err := os.Stat(srcPath); err != nil {
+			return errCopy(srcPath, dstPath, fmt.Errorf("%s doesn't exist in local filesystem", srcPath))
+		}
+		srcPaths = append(srcPaths, srcPath)
+	}
+	sort.Strings(srcPaths)
+	for _, srcPath := range srcPaths {
+		e.auditCopy(srcPath, fmt.Sprintf("pod:%s", files[srcPath]))
+	}
+	if e.EnabledAgent() {
+		for _, srcPath := range srcPaths {
+			if err := e.agentClient.CopyTo(context.Background(), srcPath, files[srcPath]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	srcText := strings.Join(srcPaths, ",")
+	dstPaths := make([]string, 0, len(srcPaths))
+	for _, srcPath := range srcPaths {
+		dstPaths = append(dstPaths, files[srcPath])
+	}
+	dstText := strings.Join(dstPaths, ",")
+	tarCmd := []string{"tar", "--no-same-owner", "-xmf", "-", "-C", "/"}
+	return e.copyToPodWithTar(tarCmd, srcText, dstText, func(w io.Writer) error {
+		writer := tar.NewWriter(w)
+		defer writer.Close()
+		for _, srcPath := range srcPaths {
+			// archive with the absolute path of destination because tar extracts files at root directory.
+			dstPath := strings.TrimLeft(path.Clean(files[srcPath]), "/")
+			srcPath = path.Clean(srcPath)
+			if err := e.writeRecursiveWithTar(
+				writer,
+				path.Dir(srcPath),
+				path.Base(srcPath),
+				path.Dir(dstPath),
+				dstPath,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (e *JobExecutor) copyToPodWithTar(tarCmd []string, srcPath, dstPath string, writeTar func(io.Writer) error) error {
 	pod := e.Pod
 	req := e.job.restClient.Post().
 		Namespace(pod.Namespace).
@@ -64,7 +134,7 @@ func (e *JobExecutor) CopyToPod(srcPath, dstPath string) error {
 			Stderr:    true,
 		}, scheme.ParameterCodec)
 	url := req.URL()
-	exec, err := remotecommand.NewSPDYExecutor(e.job.config, "POST", url)
+	exec, err := e.newSPDYExecutor(url, nil)
 	if err != nil {
 		return fmt.Errorf("job: failed to create spdy executor: %w", err)
 	}
@@ -74,7 +144,7 @@ func (e *JobExecutor) CopyToPod(srcPath, dstPath string) error {
 	var writerErr error
 	go func() {
 		defer writer.Close()
-		writerErr = e.writeWithTar(writer, srcPath, dstPath)
+		writerErr = writeTar(writer)
 	}()
 
 	var (
@@ -102,18 +172,81 @@ func (e *JobExecutor) CopyToPod(srcPath, dstPath string) error {
 	return nil
 }
 
+// CopyStats is the summary of the copy operation.
+type CopyStats struct {
+	// Bytes is the total size of the copied regular files.
+	Bytes int64
+	// Files is the number of the copied regular files.
+	Files int
+	// Duration is the time taken by the copy operation.
+	Duration time.Duration
+}
+
+// CopyToPodStats copy directory or files to specified path on Pod like CopyToPod and returns the summary of the copy.
+func (e *JobExecutor) CopyToPodStats(srcPath, dstPath string) (*CopyStats, error) {
+	startedAt := time.Now()
+	stats := &CopyStats{}
+	if err := localFileStats(srcPath, stats); err != nil {
+		return nil, errCopy(srcPath, dstPath, err)
+	}
+	if err := e.CopyToPod(srcPath, dstPath); err != nil {
+		return nil, err
+	}
+	stats.Duration = time.Since(startedAt)
+	return stats, nil
+}
+
+// CopyFromPodStats copy directory or files from specified path on Pod like CopyFromPod and returns the summary of the copy.
+func (e *JobExecutor) CopyFromPodStats(srcPath, dstPath string) (*CopyStats, error) {
+	startedAt := time.Now()
+	stats := &CopyStats{}
+	if err := e.copyFromPodWithStats(srcPath, dstPath, stats); err != nil {
+		return nil, err
+	}
+	stats.Duration = time.Since(startedAt)
+	return stats, nil
+}
+
+// localFileStats counts the regular files and their size under the path matched by srcPath.
+func localFileStats(srcPath string, stats *CopyStats) error {
+	matchedPaths, err := filepath.Glob(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to glob from %s: %w", srcPath, err)
+	}
+	for _, matchedPath := range matchedPaths {
+		if err := filepath.Walk(matchedPath, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				stats.Files++
+				stats.Bytes += info.Size()
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to walk %s: %w", matchedPath, err)
+		}
+	}
+	return nil
+}
+
 // CopyFromPod copy directory or files from specified path on Pod.
 func (e *JobExecutor) CopyFromPod(srcPath, dstPath string) error {
+	return e.copyFromPodWithStats(srcPath, dstPath, nil)
+}
+
+func (e *JobExecutor) copyFromPodWithStats(srcPath, dstPath string, stats *CopyStats) error {
 	if e.stopped {
 		return fmt.Errorf("job: failed to copy from pod. pod is already stopped")
 	}
+	e.auditCopy(fmt.Sprintf("pod:%s", srcPath), dstPath)
 	if e.EnabledAgent() {
-		return e.agentClient.CopyFrom(context.Background(), srcPath, dstPath)
+		return e.agentClient.copyFromWithStats(context.Background(), srcPath, dstPath, stats)
 	}
-	return e.copyFromPodWithRetry(srcPath, dstPath)
+	return e.copyFromPodWithRetry(srcPath, dstPath, stats)
 }
 
-func (e *JobExecutor) copyFromPodWithRetry(srcPath, dstPath string) error {
+func (e *JobExecutor) copyFromPodWithRetry(srcPath, dstPath string, stats *CopyStats) error {
 	const copyRetryCount = 3
 
 	policy := backoff.NewExponential(
@@ -128,7 +261,11 @@ func (e *JobExecutor) copyFromPodWithRetry(srcPath, dstPath string) error {
 		retryCount int
 	)
 	for backoff.Continue(b) {
-		err = e.copyFromPod(srcPath, dstPath)
+		if stats != nil {
+			// the files copied by the failed attempt are removed, so count again.
+			*stats = CopyStats{}
+		}
+		err = e.copyFromPod(srcPath, dstPath, stats)
 		if err != nil {
 			if e.isRetryableError(err) {
 				if err := os.RemoveAll(dstPath); err != nil {
@@ -171,7 +308,7 @@ func (e *JobExecutor) isRetryableError(err error) bool {
 	return false
 }
 
-func (e *JobExecutor) copyFromPod(srcPath, dstPath string) error {
+func (e *JobExecutor) copyFromPod(srcPath, dstPath string, stats *CopyStats) error {
 	if len(srcPath) == 0 || len(dstPath) == 0 {
 		return errCopyWithEmptyPath(srcPath, dstPath)
 	}
@@ -190,7 +327,7 @@ func (e *JobExecutor) copyFromPod(srcPath, dstPath string) error {
 			Stderr:    true,
 		}, scheme.ParameterCodec)
 	url := req.URL()
-	exec, err := remotecommand.NewSPDYExecutor(e.job.config, "POST", url)
+	exec, err := e.newSPDYExecutor(url, nil)
 	if err != nil {
 		return fmt.Errorf("job: failed to create spdy executor: %w", err)
 	}
@@ -222,7 +359,7 @@ func (e *JobExecutor) copyFromPod(srcPath, dstPath string) error {
 	// tar trims the leading '/' if it's there
 	tarPrefix := strings.TrimLeft(srcPath, "/")
 	tarPrefix = e.trimShortcutPath(path.Clean(tarPrefix))
-	readerErr := e.untarAll(reader, &readerErrCapturer, tarPrefix, srcPath, dstPath)
+	readerErr := e.untarAll(reader, &readerErrCapturer, tarPrefix, srcPath, dstPath, stats)
 	if e.isRetryableError(readerErr) {
 		return readerErr
 	}
@@ -361,7 +498,7 @@ func (e *JobExecutor) writeRecursiveWithTar(w *tar.Writer, srcBase, srcFile, dst
 	return nil
 }
 
-func (e *JobExecutor) untarAll(r io.Reader, errCapturer io.Writer, prefix, srcPath, dstPath string) error {
+func (e *JobExecutor) untarAll(r io.Reader, errCapturer io.Writer, prefix, srcPath, dstPath string, stats *CopyStats) error {
 	tarReader := tar.NewReader(r)
 	for {
 		header, err := tarReader.Next()
@@ -411,6 +548,10 @@ func (e *JobExecutor) untarAll(r io.Reader, errCapturer io.Writer, prefix, srcPa
 			}
 			return fmt.Errorf("failed to copy file from reader %s: %w", dstFileName, err)
 		}
+		if stats != nil && mode.IsRegular() {
+			stats.Files++
+			stats.Bytes += header.Size
+		}
 	}
 
 	return nil
@@ -1,11 +1,14 @@
 package kubejob
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	executil "k8s.io/client-go/util/exec"
 )
 
@@ -61,6 +64,87 @@ func (j *FailedJob) Error() string {
 	return "job: failed to job"
 }
 
+func (j *FailedJob) Unwrap() error {
+	return j.Reason
+}
+
+// ArchMismatchError is returned when the container failed by `exec format error`.
+// It usually means the architecture of the image mismatches the node.
+type ArchMismatchError struct {
+	Pod           *corev1.Pod
+	ContainerName string
+	Message       string
+}
+
+func (e *ArchMismatchError) Error() string {
+	return fmt.Sprintf(
+		"job: failed to run container %s. the image architecture may mismatch the node: %s",
+		e.ContainerName,
+		e.Message,
+	)
+}
+
+// Unwrap returns FailedJob so that the error can be handled as the failed job.
+func (e *ArchMismatchError) Unwrap() error {
+	return &FailedJob{Pod: e.Pod, Reason: errors.New(e.Message)}
+}
+
+// OOMKilledError is returned when the container was killed by exceeding the memory limit.
+type OOMKilledError struct {
+	Pod       *corev1.Pod
+	Container string
+	// Limit is the memory limit of the container. If the limit is not specified, it's zero.
+	Limit resource.Quantity
+}
+
+func (e *OOMKilledError) Error() string {
+	if e.Limit.IsZero() {
+		return fmt.Sprintf("job: container %s was OOMKilled", e.Container)
+	}
+	return fmt.Sprintf("job: container %s was OOMKilled. memory limit is %s", e.Container, e.Limit.String())
+}
+
+// Unwrap returns FailedJob so that the error can be handled as the failed job.
+func (e *OOMKilledError) Unwrap() error {
+	return &FailedJob{Pod: e.Pod, Reason: fmt.Errorf("container %s was OOMKilled", e.Container)}
+}
+
+// PodEvictedError is returned when the pod is evicted ( e.g. by the node pressure ).
+// In this case, the workload itself didn't fail, so the Job may succeed by retrying ( see SetRetryOnEviction ).
+type PodEvictedError struct {
+	Pod     *corev1.Pod
+	Message string
+}
+
+func (e *PodEvictedError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("job: pod %s was evicted", e.Pod.Name)
+	}
+	return fmt.Sprintf("job: pod %s was evicted: %s", e.Pod.Name, e.Message)
+}
+
+// Unwrap returns FailedJob so that the error can be handled as the failed job.
+func (e *PodEvictedError) Unwrap() error {
+	return &FailedJob{Pod: e.Pod, Reason: fmt.Errorf("pod %s was evicted", e.Pod.Name)}
+}
+
+// DeadlineExceededError is returned when the Job was terminated by exceeding ActiveDeadlineSeconds.
+type DeadlineExceededError struct {
+	JobName  string
+	Pod      *corev1.Pod
+	Deadline time.Duration
+	Message  string
+}
+
+func (e *DeadlineExceededError) Error() string {
+	return fmt.Sprintf("job: %s exceeded the active deadline %s: %s", e.JobName, e.Deadline, e.Message)
+}
+
+// Unwrap returns FailedJob so that the error can be handled as the failed job.
+func (e *DeadlineExceededError) Unwrap() error {
+	return &FailedJob{Pod: e.Pod, Reason: errors.New(e.Message)}
+}
+
 type CleanupError struct {
 	JobName string
 	Errs    []error
@@ -100,6 +184,33 @@ func (e *LogStreamError) Error() string {
 	)
 }
 
+type PreflightFieldError struct {
+	Field   string
+	Type    string
+	Message string
+}
+
+type PreflightError struct {
+	JobName     string
+	FieldErrors []PreflightFieldError
+	Err         error
+}
+
+func (e *PreflightError) Error() string {
+	if len(e.FieldErrors) == 0 {
+		return fmt.Sprintf("job: preflight of job %s failed: %s", e.JobName, e.Err)
+	}
+	msgs := make([]string, 0, len(e.FieldErrors))
+	for _, fieldErr := range e.FieldErrors {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", fieldErr.Field, fieldErr.Message))
+	}
+	return fmt.Sprintf("job: preflight of job %s failed: %s", e.JobName, strings.Join(msgs, ". "))
+}
+
+func (e *PreflightError) Unwrap() error {
+	return e.Err
+}
+
 type JobCreationError struct {
 	JobName         string
 	JobGenerateName string
@@ -124,6 +235,23 @@ func (e *JobCreationError) Error() string {
 	return fmt.Sprintf("job: failed to create job: %s", e.Err)
 }
 
+type NamespaceNotFoundError struct {
+	Namespace string
+	Err       error
+}
+
+func (e *NamespaceNotFoundError) Error() string {
+	return fmt.Sprintf(
+		"job: namespace %s is not found. please create the namespace before running the job: %s",
+		e.Namespace,
+		e.Err,
+	)
+}
+
+func (e *NamespaceNotFoundError) Unwrap() error {
+	return e.Err
+}
+
 type JobWatchError struct {
 	JobName string
 	Err     error
@@ -133,6 +261,25 @@ func (e *JobWatchError) Error() string {
 	return fmt.Sprintf("job: failed to watch job %s: %s", e.JobName, e.Err)
 }
 
+// ConcurrentRunError is returned when another Job with the same concurrency key is running.
+type ConcurrentRunError struct {
+	Key        string
+	RunningJob string
+}
+
+func (e *ConcurrentRunError) Error() string {
+	return fmt.Sprintf("job: job %s with the same concurrency key %s is running", e.RunningJob, e.Key)
+}
+
+type JobDeletedError struct {
+	JobName string
+	Pod     *corev1.Pod
+}
+
+func (e *JobDeletedError) Error() string {
+	return fmt.Sprintf("job: job %s ( pod %s ) was deleted while watching", e.JobName, e.Pod.Name)
+}
+
 type ValidationError struct {
 	Required string
 	Err      error
@@ -148,6 +295,24 @@ func (e *ValidationError) Error() string {
 	return ""
 }
 
+type UndefinedVariableError struct {
+	Names []string
+}
+
+func (e *UndefinedVariableError) Error() string {
+	return fmt.Sprintf("job: undefined variables in manifest: %s", strings.Join(e.Names, ", "))
+}
+
+type InvalidLabelError struct {
+	Key   string
+	Value string
+	Errs  []string
+}
+
+func (e *InvalidLabelError) Error() string {
+	return fmt.Sprintf("job: invalid label %s=%s: %s", e.Key, e.Value, strings.Join(e.Errs, ". "))
+}
+
 type PreInitError struct {
 	Err error
 }
@@ -160,6 +325,20 @@ type CommandError struct {
 	Message   string
 	ReaderErr error
 	WriterErr error
+	exitCode  int
+}
+
+// exitStatus returns the exit code of the command if the command exited with non-zero status.
+func (e *CommandError) exitStatus() (int, bool) {
+	if e.exitCode != 0 {
+		return e.exitCode, true
+	}
+	for _, err := range []error{e.ReaderErr, e.WriterErr} {
+		if exitErr, ok := err.(executil.ExitError); ok {
+			return exitErr.ExitStatus(), true
+		}
+	}
+	return 0, false
 }
 
 func (e *CommandError) IsExitError() bool {
@@ -189,6 +368,21 @@ func (e *CommandError) Error() string {
 	return fmt.Sprintf("job: failed to run command: %s", strings.Join(msgs, ". "))
 }
 
+// CommandTimeoutError is returned when the command is killed by the timeout set by `(*JobExecutor).SetCommandTimeout`.
+type CommandTimeoutError struct {
+	Command []string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *CommandTimeoutError) Error() string {
+	return fmt.Sprintf("job: command %q timed out after %s", strings.Join(e.Command, " "), e.Timeout)
+}
+
+func (e *CommandTimeoutError) Unwrap() error {
+	return e.Err
+}
+
 type JobStopContainerError struct {
 	Reason error
 }
@@ -209,6 +403,27 @@ func (e *PendingPhaseTimeoutError) Error() string {
 	)
 }
 
+type PendingTimeoutError struct {
+	Timeout   time.Duration
+	CreatedAt time.Time
+}
+
+func (e *PendingTimeoutError) Error() string {
+	return fmt.Sprintf(
+		"job: failed to move running phase. %s has passed since the pod was created at %s",
+		e.Timeout,
+		e.CreatedAt.Format(time.RFC3339),
+	)
+}
+
+type PriorityClassNotFoundError struct {
+	Name string
+}
+
+func (e *PriorityClassNotFoundError) Error() string {
+	return fmt.Sprintf("job: priority class %s is not found", e.Name)
+}
+
 type CopyError struct {
 	SrcPath string
 	DstPath string
@@ -226,6 +441,49 @@ func errPendingPhase(startedAt time.Time, timeout time.Duration) error {
 	}
 }
 
+func errPendingTimeout(createdAt time.Time, timeout time.Duration) error {
+	return &PendingTimeoutError{
+		CreatedAt: createdAt,
+		Timeout:   timeout,
+	}
+}
+
+func errArchMismatch(pod *corev1.Pod, containerName, message string) error {
+	return &ArchMismatchError{
+		Pod:           pod,
+		ContainerName: containerName,
+		Message:       message,
+	}
+}
+
+func errPodEvicted(pod *corev1.Pod, message string) error {
+	return &PodEvictedError{
+		Pod:     pod,
+		Message: message,
+	}
+}
+
+func errOOMKilled(pod *corev1.Pod, container string, limit resource.Quantity) error {
+	return &OOMKilledError{
+		Pod:       pod,
+		Container: container,
+		Limit:     limit,
+	}
+}
+
+func errDeadlineExceeded(jobName string, pod *corev1.Pod, deadline time.Duration, message string) error {
+	return &DeadlineExceededError{
+		JobName:  jobName,
+		Pod:      pod,
+		Deadline: deadline,
+		Message:  message,
+	}
+}
+
+func errPriorityClassNotFound(name string) error {
+	return &PriorityClassNotFoundError{Name: name}
+}
+
 func errCopy(srcPath, dstPath string, err error) error {
 	return &CopyError{
 		SrcPath: srcPath,
@@ -271,9 +529,18 @@ func errCommand(reader, writer error) error {
 	}
 }
 
-func errCommandFromAgent(msg string) error {
+func errCommandFromAgent(msg string, exitCode int) error {
 	return &CommandError{
-		Message: msg,
+		Message:  msg,
+		exitCode: exitCode,
+	}
+}
+
+func errCommandTimeout(cmd []string, timeout time.Duration, err error) error {
+	return &CommandTimeoutError{
+		Command: cmd,
+		Timeout: timeout,
+		Err:     err,
 	}
 }
 
@@ -285,10 +552,42 @@ func errInvalidYAML(err error) error {
 	return &ValidationError{Err: err}
 }
 
+func errUndefinedVariable(names []string) error {
+	return &UndefinedVariableError{Names: names}
+}
+
+func errInvalidLabel(key, value string, errs []string) error {
+	return &InvalidLabelError{
+		Key:   key,
+		Value: value,
+		Errs:  errs,
+	}
+}
+
 func errRequiredParam(required string) error {
 	return &ValidationError{Required: required}
 }
 
+func errPreflight(jobName string, err error) error {
+	var fieldErrs []PreflightFieldError
+	if status, ok := err.(apierrors.APIStatus); ok {
+		if details := status.Status().Details; details != nil {
+			for _, cause := range details.Causes {
+				fieldErrs = append(fieldErrs, PreflightFieldError{
+					Field:   cause.Field,
+					Type:    string(cause.Type),
+					Message: cause.Message,
+				})
+			}
+		}
+	}
+	return &PreflightError{
+		JobName:     jobName,
+		FieldErrors: fieldErrs,
+		Err:         err,
+	}
+}
+
 func errJobCreation(jobName, generateName string, err error) error {
 	return &JobCreationError{
 		JobName:         jobName,
@@ -297,6 +596,10 @@ func errJobCreation(jobName, generateName string, err error) error {
 	}
 }
 
+func errNamespaceNotFound(namespace string, err error) error {
+	return &NamespaceNotFoundError{Namespace: namespace, Err: err}
+}
+
 func errJobWatch(jobName string, err error) error {
 	return &JobWatchError{
 		JobName: jobName,
@@ -304,6 +607,14 @@ func errJobWatch(jobName string, err error) error {
 	}
 }
 
+func errConcurrentRun(key, runningJob string) error {
+	return &ConcurrentRunError{Key: key, RunningJob: runningJob}
+}
+
+func errJobDeleted(jobName string, pod *corev1.Pod) error {
+	return &JobDeletedError{JobName: jobName, Pod: pod}
+}
+
 func errCleanup(jobName string, errs []error) error {
 	return &CleanupError{
 		JobName: jobName,
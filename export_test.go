@@ -1,5 +1,16 @@
 package kubejob
 
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
 func (e *JobExecutor) ExecWithPodNotFoundError() ([]byte, error) {
 	name := e.Pod.Name
 	e.Pod.Name = "invalid-pod-name"
@@ -17,3 +28,68 @@ func SetExecRetryCount(retry int) func() {
 
 var AgentAuthUnaryInterceptor = agentAuthUnaryInterceptor
 var AgentAuthStreamInterceptor = agentAuthStreamInterceptor
+
+func (j *Job) RESTConfig() *rest.Config {
+	return j.config
+}
+
+func (j *Job) SetClientset(clientset kubernetes.Interface, namespace string) {
+	j.jobClient = clientset.BatchV1().Jobs(namespace)
+	j.podClient = clientset.CoreV1().Pods(namespace)
+	j.configMapClient = clientset.CoreV1().ConfigMaps(namespace)
+	j.secretClient = clientset.CoreV1().Secrets(namespace)
+	j.priorityClassClient = clientset.SchedulingV1().PriorityClasses()
+}
+
+func (j *Job) CreateJob(ctx context.Context) (*batchv1.Job, error) {
+	return j.createJob(ctx)
+}
+
+func (j *Job) Wait(ctx context.Context) error {
+	return j.wait(ctx)
+}
+
+func (j *Job) ShutdownSidecars(ctx context.Context, pod *corev1.Pod) error {
+	return j.shutdownSidecars(ctx, pod)
+}
+
+func (j *Job) LogStreamContainer(ctx context.Context, pod *corev1.Pod, container corev1.Container) error {
+	return j.logStreamContainer(ctx, pod, container, true, true)
+}
+
+func SetServiceAccountNamespacePath(path string) func() {
+	defaultPath := serviceAccountNamespacePath
+	serviceAccountNamespacePath = path
+	return func() { serviceAccountNamespacePath = defaultPath }
+}
+
+func (b *JobBuilder) Namespace() string {
+	return b.namespace
+}
+
+func (j *Job) NewExecutor(pod *corev1.Pod, container corev1.Container) *JobExecutor {
+	return &JobExecutor{
+		Container: container,
+		Pod:       pod,
+		job:       j,
+	}
+}
+
+func (e *JobExecutor) ExecOnce(cmd []string) ([]byte, error) {
+	return e.exec(cmd)
+}
+
+func (j *Job) LogPatternWaiterCount() int {
+	j.logPatternMu.Lock()
+	defer j.logPatternMu.Unlock()
+	return len(j.logPatternWaiters)
+}
+
+func (e *JobExecutor) SPDYTLSConfig() (*tls.Config, error) {
+	return e.job.spdyTLSConfigFor()
+}
+
+func (e *JobExecutor) SPDYTransport() (http.RoundTripper, error) {
+	transport, _, err := e.job.spdyRoundTripper()
+	return transport, err
+}
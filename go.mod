@@ -9,13 +9,12 @@ require (
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/lestrrat-go/backoff v1.0.1
 	github.com/lestrrat-go/jwx/v2 v2.0.2
+	github.com/prometheus/client_golang v1.11.0
 	github.com/rs/xid v1.2.1
 	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
-	golang.org/x/time v0.0.0-20201208040808-7e3f01d25324 // indirect
 	google.golang.org/grpc v1.47.0
 	google.golang.org/protobuf v1.27.1
-	k8s.io/api v0.20.2
-	k8s.io/apimachinery v0.20.2
-	k8s.io/client-go v0.20.2
-	k8s.io/utils v0.0.0-20210111153108-fddb29f9d009 // indirect
+	k8s.io/api v0.21.14
+	k8s.io/apimachinery v0.21.14
+	k8s.io/client-go v0.21.14
 )
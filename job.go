@@ -3,28 +3,51 @@ package kubejob
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/lestrrat-go/backoff"
 	"golang.org/x/sync/errgroup"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	typedschedulingv1 "k8s.io/client-go/kubernetes/typed/scheduling/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
 	SelectorLabel        = "kubejob.io/id"
 	DefaultJobName       = "kubejob-"
 	DefaultContainerName = "kubejob"
+	JobFinalizer         = "kubejob.io/finalizer"
+	ConcurrencyKeyLabel  = "kubejob.io/concurrency-key"
+	ImageLabel           = "kubejob.io/image"
+	CommandHashLabel     = "kubejob.io/command-hash"
+
+	// FluentBitParserAnnotation is the annotation to suggest the parser of the pod logs to Fluent Bit.
+	FluentBitParserAnnotation = "fluentbit.io/parser"
+
+	defaultCallbackReadinessTimeout = 30 * time.Second
+	commandHashLength               = 10
 )
 
 type LogLevel int
@@ -52,31 +75,94 @@ func (l LogLevel) String() string {
 }
 
 var (
-	ExecRetryCount = 8
+	ExecRetryCount          = 8
+	LogStreamReconnectCount = 3
 )
 
 type Job struct {
 	*batchv1.Job
-	jobClient                typedbatchv1.JobInterface
-	podClient                typedcorev1.PodInterface
-	restClient               rest.Interface
-	containerLogs            chan *ContainerLog
-	logger                   Logger
-	containerLogger          ContainerLogger
-	disabledInitContainerLog bool
-	disabledInitCommandLog   bool
-	disabledContainerLog     bool
-	disabledCommandLog       bool
-	logLevel                 LogLevel
-	config                   *rest.Config
-	podRunningCallback       func(*corev1.Pod) error
-	preInit                  *preInit
-	jobInit                  *jobInit
-	pendingTimeout           *time.Duration
-	agentCfg                 *AgentConfig
+	jobClient                       typedbatchv1.JobInterface
+	podClient                       typedcorev1.PodInterface
+	configMapClient                 typedcorev1.ConfigMapInterface
+	secretClient                    typedcorev1.SecretInterface
+	priorityClassClient             typedschedulingv1.PriorityClassInterface
+	restClient                      rest.Interface
+	containerLogs                   chan *ContainerLog
+	logStreamWG                     sync.WaitGroup
+	logStreamMu                     sync.Mutex
+	logStreamClosed                 bool
+	logger                          Logger
+	containerLogger                 ContainerLogger
+	contextLogger                   ContextLogger
+	disabledInitContainerLog        bool
+	disabledInitCommandLog          bool
+	disabledContainerLog            bool
+	disabledCommandLog              bool
+	logLevel                        LogLevel
+	config                          *rest.Config
+	podRunningCallback              func(*corev1.Pod) error
+	preInit                         *preInit
+	jobInit                         *jobInit
+	pendingTimeout                  *time.Duration
+	podPendingTimeout               *time.Duration
+	agentCfg                        *AgentConfig
+	createdJob                      *batchv1.Job
+	createdHandler                  func(*batchv1.Job)
+	executionWrapper                ExecutionWrapper
+	logCh                           chan *ContainerLog
+	createRetryCount                int
+	createRetryInterval             time.Duration
+	logBufferSize                   int
+	callbackReadinessTimeout        *time.Duration
+	manifestConfigMaps              []*corev1.ConfigMap
+	manifestSecrets                 []*corev1.Secret
+	createdConfigMapNames           []string
+	createdSecretNames              []string
+	watchTimeout                    *time.Duration
+	lastResourceVersion             string
+	lastPod                         *corev1.Pod
+	logDrainTimeout                 *time.Duration
+	sidecarShutdown                 bool
+	stdinFile                       string
+	stdinFileApplied                bool
+	metricsCollector                MetricsCollector
+	statusCh                        chan *JobStatus
+	statusMu                        sync.Mutex
+	statusClosed                    bool
+	keepAliveAfterHandler           time.Duration
+	auditHandler                    AuditHandler
+	logContainerNameMap             map[string]struct{}
+	streamProtocols                 []string
+	trackingLabelKey                string
+	parallelism                     *int32
+	parallelismMu                   sync.RWMutex
+	treatSidecarFailureAsJobFailure bool
+	logPatternWaiters               []*logPatternWaiter
+	logPatternMu                    sync.Mutex
+	logChannelBuffer                int
+	preHandlerHook                  func(*corev1.Pod) error
+	failFast                        *failFast
+	deletePropagation               *metav1.DeletionPropagation
+	stdout                          io.Writer
+	stderr                          io.Writer
+	os                              OS
+	commandLogWriter                io.Writer
+	evictionRetryCount              int
+	lastPodMu                       sync.RWMutex
+	nodeName                        string
+	nodeScheduled                   chan struct{}
+	restartHandler                  func(string, int32)
+	resetExecutionHandler           func()
+	spdyTLSConfig                   *tls.Config
+	spdyTransport                   http.RoundTripper
+	spdyUpgrader                    spdy.Upgrader
+	spdyMu                          sync.Mutex
 }
 
 type ContainerLogger func(*ContainerLog)
+
+// ContextLogger is the ContainerLogger that receives the context passed to Run.
+type ContextLogger func(context.Context, *ContainerLog)
 type Logger func(string)
 
 type ContainerLog struct {
@@ -86,12 +172,527 @@ type ContainerLog struct {
 	IsFinished bool
 }
 
+// CompletionIndex returns the completion index of the pod when the Job is Indexed completion mode.
+func (l *ContainerLog) CompletionIndex() (string, bool) {
+	if l.Pod == nil {
+		return "", false
+	}
+	index, exists := l.Pod.Annotations[batchv1.JobCompletionIndexAnnotationAlpha]
+	return index, exists
+}
+
 // SetPendingPhaseTimeout set the timeout when the process in the init container is finished
 // but it does not switch to the Running phase ( PodInitializing state for a long time ).
 func (j *Job) SetPendingPhaseTimeout(timeout time.Duration) {
 	j.pendingTimeout = &timeout
 }
 
+// SetPendingTimeout set the timeout from the pod creation until it switches to the Running phase.
+// This is useful to fail fast when the pod cannot be scheduled.
+// Once the pod is running, it can take as long as needed.
+func (j *Job) SetPendingTimeout(timeout time.Duration) {
+	j.podPendingTimeout = &timeout
+}
+
+// SetCreatedHandler set the callback that is called right after the Job is created.
+// The passed Job has the server-assigned fields like UID and name.
+func (j *Job) SetCreatedHandler(handler func(*batchv1.Job)) {
+	j.createdHandler = handler
+}
+
+// SetRestartHandler set the callback that is called when the restart count of the container increases.
+// This is useful to react to the crash looping container before the backoff limit is exhausted.
+func (j *Job) SetRestartHandler(handler func(container string, count int32)) {
+	j.restartHandler = handler
+}
+
+// CreatedJob returns the Job object returned by the API server at creation.
+// If the Job has not been created yet, returns nil.
+func (j *Job) CreatedJob() *batchv1.Job {
+	return j.createdJob
+}
+
+// ContainerStatus returns the status of the specified container ( or init container ) from the last observed pod.
+// This is useful to get the termination state ( e.g. StartedAt and FinishedAt ) after Run.
+// If the pod has not been observed yet or the container is not found, returns false.
+func (j *Job) ContainerStatus(containerName string) (*corev1.ContainerStatus, bool) {
+	j.lastPodMu.RLock()
+	defer j.lastPodMu.RUnlock()
+	if j.lastPod == nil {
+		return nil, false
+	}
+	statuses := append(
+		append([]corev1.ContainerStatus{}, j.lastPod.Status.InitContainerStatuses...),
+		j.lastPod.Status.ContainerStatuses...,
+	)
+	for idx := range statuses {
+		if statuses[idx].Name == containerName {
+			return &statuses[idx], true
+		}
+	}
+	return nil, false
+}
+
+// GetContainerLogs returns the logs of the specified container without following.
+// This is simpler than the streaming logger to retrieve the final logs once ( e.g. in the execution handler after the command finished ).
+// Note that the pod is deleted when Run returns, so this must be called before that.
+func (j *Job) GetContainerLogs(ctx context.Context, containerName string) ([]byte, error) {
+	j.lastPodMu.RLock()
+	pod := j.lastPod
+	j.lastPodMu.RUnlock()
+	if pod == nil {
+		return nil, fmt.Errorf("job: failed to get logs of %s. pod is not observed yet", containerName)
+	}
+	logs, err := j.podClient.GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: containerName,
+		Follow:    false,
+	}).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("job: failed to get logs of %s: %w", containerName, err)
+	}
+	return logs, nil
+}
+
+// PreviousContainerLogs returns the logs of the previous terminated instance of the specified container.
+// This is useful to diagnose the container that was restarted.
+func (j *Job) PreviousContainerLogs(ctx context.Context, containerName string) ([]byte, error) {
+	j.lastPodMu.RLock()
+	pod := j.lastPod
+	j.lastPodMu.RUnlock()
+	if pod == nil {
+		return nil, fmt.Errorf("job: failed to get previous logs of %s. pod is not observed yet", containerName)
+	}
+	logs, err := j.podClient.GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: containerName,
+		Previous:  true,
+	}).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("job: failed to get previous logs of %s: %w", containerName, err)
+	}
+	return logs, nil
+}
+
+func (j *Job) setLastPod(pod *corev1.Pod) {
+	j.lastPodMu.Lock()
+	defer j.lastPodMu.Unlock()
+	j.lastPod = pod.DeepCopy()
+	if pod.Spec.NodeName != "" && j.nodeName == "" {
+		j.nodeName = pod.Spec.NodeName
+		if j.nodeScheduled == nil {
+			j.nodeScheduled = make(chan struct{})
+		}
+		close(j.nodeScheduled)
+	}
+}
+
+func (j *Job) nodeScheduledChan() chan struct{} {
+	j.lastPodMu.Lock()
+	defer j.lastPodMu.Unlock()
+	if j.nodeScheduled == nil {
+		j.nodeScheduled = make(chan struct{})
+	}
+	return j.nodeScheduled
+}
+
+// WaitForNode waits until the pod is scheduled onto the node and returns the node name.
+// This returns before the pod switches to the Running phase, so it's useful to prepare the node-local state.
+// This must be called concurrently with Run.
+func (j *Job) WaitForNode(ctx context.Context) (string, error) {
+	select {
+	case <-ctx.Done():
+		return "", fmt.Errorf("job: failed to wait for the pod to be scheduled: %w", ctx.Err())
+	case <-j.nodeScheduledChan():
+	}
+	j.lastPodMu.RLock()
+	defer j.lastPodMu.RUnlock()
+	return j.nodeName, nil
+}
+
+type logPatternWaiter struct {
+	container string
+	re        *regexp.Regexp
+	done      chan error
+}
+
+// WaitForLogPattern waits until the log of the specified container matches the pattern.
+// This is useful to wait for the server that has no readiness probe ( e.g. "server started" ).
+// If the container exits without matching, this returns an error.
+// This must be called concurrently with Run, and the logs streamed before calling this are not scanned.
+func (j *Job) WaitForLogPattern(ctx context.Context, container string, re *regexp.Regexp) error {
+	waiter := &logPatternWaiter{
+		container: container,
+		re:        re,
+		done:      make(chan error, 1),
+	}
+	j.logPatternMu.Lock()
+	j.logPatternWaiters = append(j.logPatternWaiters, waiter)
+	j.logPatternMu.Unlock()
+	defer j.removeLogPatternWaiter(waiter)
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("job: failed to wait for the log pattern %q of %s: %w", re, container, ctx.Err())
+	case err := <-waiter.done:
+		return err
+	}
+}
+
+func (j *Job) removeLogPatternWaiter(waiter *logPatternWaiter) {
+	j.logPatternMu.Lock()
+	defer j.logPatternMu.Unlock()
+	for idx, w := range j.logPatternWaiters {
+		if w == waiter {
+			j.logPatternWaiters = append(j.logPatternWaiters[:idx], j.logPatternWaiters[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (j *Job) matchLogPattern(container string, line []byte) {
+	j.logPatternMu.Lock()
+	defer j.logPatternMu.Unlock()
+	waiters := j.logPatternWaiters[:0]
+	for _, w := range j.logPatternWaiters {
+		if w.container == container && w.re.Match(line) {
+			w.done <- nil
+			continue
+		}
+		waiters = append(waiters, w)
+	}
+	j.logPatternWaiters = waiters
+}
+
+func (j *Job) finishLogPattern(container string) {
+	j.logPatternMu.Lock()
+	defer j.logPatternMu.Unlock()
+	waiters := j.logPatternWaiters[:0]
+	for _, w := range j.logPatternWaiters {
+		if container == "" || w.container == container {
+			w.done <- fmt.Errorf("job: %s exited without the log matching %q", w.container, w.re)
+			continue
+		}
+		waiters = append(waiters, w)
+	}
+	j.logPatternWaiters = waiters
+}
+
+// Suspend suspends the running Job. Active pods are terminated by the Job controller.
+func (j *Job) Suspend(ctx context.Context) error {
+	return j.patchSuspend(ctx, true)
+}
+
+// Resume resumes the suspended Job. While the Job is suspended, Run waits until the pod starts.
+func (j *Job) Resume(ctx context.Context) error {
+	return j.patchSuspend(ctx, false)
+}
+
+func (j *Job) patchSuspend(ctx context.Context, suspend bool) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"suspend":%t}}`, suspend))
+	if _, err := j.jobClient.Patch(ctx, j.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("job: failed to patch suspend to %t: %w", suspend, err)
+	}
+	return nil
+}
+
+// SetTreatSidecarFailureAsJobFailure set whether the failure of the command executed by ExecAsync makes the Job failed
+// when running with the execution handler. The command is typically the sidecar like a proxy or a database for testing.
+// If enabled and the command has already failed when the handler returns, Run returns *FailedJob with the reason.
+func (j *Job) SetTreatSidecarFailureAsJobFailure(enabled bool) {
+	j.treatSidecarFailureAsJobFailure = enabled
+}
+
+// SetConcurrencyKey set the key to prevent the Jobs with the same key from running at the same time.
+// The key is added to the Job as ConcurrencyKeyLabel, so it must be a valid label value.
+// When Run starts, if another Job with the same key is not finished yet, Run returns *ConcurrentRunError.
+// This is checked by the application, so the Jobs started at exactly the same time may not be excluded.
+func (j *Job) SetConcurrencyKey(key string) {
+	if j.Labels == nil {
+		j.Labels = map[string]string{}
+	}
+	j.Labels[ConcurrencyKeyLabel] = key
+}
+
+func (j *Job) checkConcurrentRun(ctx context.Context) error {
+	key, exists := j.Labels[ConcurrencyKeyLabel]
+	if !exists {
+		return nil
+	}
+	jobs, err := j.jobClient.List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", ConcurrencyKeyLabel, key),
+	})
+	if err != nil {
+		return fmt.Errorf("job: failed to list jobs with concurrency key %s: %w", key, err)
+	}
+	for _, job := range jobs.Items {
+		if job.DeletionTimestamp != nil || isFinishedJob(&job) {
+			continue
+		}
+		return errConcurrentRun(key, job.Name)
+	}
+	return nil
+}
+
+func isFinishedJob(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete, batchv1.JobFailed:
+			return true
+		}
+	}
+	return false
+}
+
+// SetParallelismRuntime patches the parallelism of the running Job.
+// The pods created by the Job controller after that are also tracked until the Job is finished.
+// The pods deleted by the Job controller to scale down are not treated as the deletion of the Job ( JobDeletedError ).
+func (j *Job) SetParallelismRuntime(ctx context.Context, n int32) error {
+	if n < 0 {
+		return fmt.Errorf("job: invalid parallelism %d. parallelism must not be negative", n)
+	}
+	if j.createdJob == nil {
+		return fmt.Errorf("job: failed to patch parallelism. job is not created yet")
+	}
+	patch := []byte(fmt.Sprintf(`{"spec":{"parallelism":%d}}`, n))
+	if _, err := j.jobClient.Patch(ctx, j.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("job: failed to patch parallelism to %d: %w", n, err)
+	}
+	j.parallelismMu.Lock()
+	defer j.parallelismMu.Unlock()
+	j.parallelism = &n
+	return nil
+}
+
+// isParallelismPatched returns true if the parallelism is changed by SetParallelismRuntime.
+func (j *Job) isParallelismPatched() bool {
+	j.parallelismMu.RLock()
+	defer j.parallelismMu.RUnlock()
+	return j.parallelism != nil
+}
+
+// isParallel returns true if the Job may run multiple pods at the same time.
+// In this case, the completion of the Job is determined by its conditions instead of the phase of each pod.
+func (j *Job) isParallel() bool {
+	j.parallelismMu.RLock()
+	defer j.parallelismMu.RUnlock()
+	if j.parallelism != nil {
+		return *j.parallelism > 1
+	}
+	return j.Spec.Parallelism != nil && *j.Spec.Parallelism > 1
+}
+
+// waitForJobCompletion polls the conditions of the Job until it is finished.
+func (j *Job) waitForJobCompletion(ctx context.Context, pod *corev1.Pod) error {
+	for {
+		done, err := j.checkJobCompletion(ctx, pod)
+		if done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// OverrideImage replaces the image of the specified container ( or init container ).
+// This must be called before Run.
+func (j *Job) OverrideImage(containerName, image string) error {
+	spec := &j.Job.Spec.Template.Spec
+	for idx := range spec.InitContainers {
+		if spec.InitContainers[idx].Name == containerName {
+			spec.InitContainers[idx].Image = image
+			return nil
+		}
+	}
+	for idx := range spec.Containers {
+		if spec.Containers[idx].Name == containerName {
+			spec.Containers[idx].Image = image
+			return nil
+		}
+	}
+	return fmt.Errorf("job: failed to override image. container %s is not found", containerName)
+}
+
+// OverrideAllImages replaces the images of all containers and init containers.
+// This must be called before Run.
+func (j *Job) OverrideAllImages(image string) {
+	spec := &j.Job.Spec.Template.Spec
+	for idx := range spec.InitContainers {
+		spec.InitContainers[idx].Image = image
+	}
+	for idx := range spec.Containers {
+		spec.Containers[idx].Image = image
+	}
+}
+
+// SetCreateRetry set the retry count and the backoff interval for the transient errors at creating the Job.
+// kubejob retries only when the API server is busy or the network error occurs,
+// the validation or conflict errors are returned immediately.
+// The timeout error is not retried because the Job may have been created by the API server.
+// By default, kubejob doesn't retry.
+func (j *Job) SetCreateRetry(count int, interval time.Duration) {
+	j.createRetryCount = count
+	j.createRetryInterval = interval
+}
+
+// notFoundNamespace returns the namespace name if the error is caused by the nonexistent namespace.
+func (j *Job) notFoundNamespace(err error) (string, bool) {
+	if !apierrors.IsNotFound(err) {
+		return "", false
+	}
+	var statusErr *apierrors.StatusError
+	if !errors.As(err, &statusErr) {
+		return "", false
+	}
+	details := statusErr.ErrStatus.Details
+	if details == nil || details.Kind != "namespaces" {
+		return "", false
+	}
+	return details.Name, true
+}
+
+func (j *Job) isRetryableCreateError(err error) bool {
+	if apierrors.IsServerTimeout(err) || apierrors.IsTooManyRequests(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (j *Job) createJob(ctx context.Context) (*batchv1.Job, error) {
+	policy := backoff.NewExponential(
+		backoff.WithInterval(j.createRetryInterval),
+		backoff.WithMaxRetries(j.createRetryCount),
+	)
+	b, cancel := policy.Start(ctx)
+	defer cancel()
+
+	var (
+		job        *batchv1.Job
+		err        error
+		retryCount int
+	)
+	for backoff.Continue(b) {
+		job, err = j.jobClient.Create(ctx, j.Job, metav1.CreateOptions{})
+		// WithMaxRetries(0) means unlimited retries for backoff, so the retry count is bounded by createRetryCount.
+		if err != nil && j.isRetryableCreateError(err) && retryCount < j.createRetryCount {
+			j.logDebug("failed to create job: %s. retry: %d/%d", err, retryCount, j.createRetryCount)
+			retryCount++
+			continue
+		}
+		break
+	}
+	return job, err
+}
+
+// Preflight validates the current Job spec by the API server using server-side dry-run without creating the Job.
+// This is useful to detect the spec that the API server rejects ( e.g. invalid resource quantity ) before committing to a run.
+// If the spec is invalid, returns *PreflightError that has the field errors.
+func (j *Job) Preflight(ctx context.Context) error {
+	if _, err := j.jobClient.Create(ctx, j.Job, metav1.CreateOptions{
+		DryRun: []string{metav1.DryRunAll},
+	}); err != nil {
+		jobName := j.Name
+		if jobName == "" {
+			jobName = j.GenerateName
+		}
+		return errPreflight(jobName, err)
+	}
+	return nil
+}
+
+// SetWatchTimeout set the timeout of the watch request for the pod.
+// When the watch is closed, kubejob resumes it from the last seen resourceVersion.
+func (j *Job) SetWatchTimeout(timeout time.Duration) {
+	j.watchTimeout = &timeout
+}
+
+// SetCallbackReadinessTimeout set the timeout to wait for all containers to be the running state
+// before calling the execution handler. The default value is 30 seconds.
+func (j *Job) SetCallbackReadinessTimeout(timeout time.Duration) {
+	j.callbackReadinessTimeout = &timeout
+}
+
+func (j *Job) isRunningAllContainers(status corev1.PodStatus) bool {
+	if len(status.ContainerStatuses) == 0 {
+		return false
+	}
+	for _, s := range status.ContainerStatuses {
+		if s.State.Running == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// waitForRunningAllContainers waits for all containers to be the running state, and returns the latest pod.
+// The pod phase may be Running before the processes of the containers are actually started,
+// so exec to the container may fail at that time.
+func (j *Job) waitForRunningAllContainers(ctx context.Context, pod *corev1.Pod) (*corev1.Pod, error) {
+	timeout := defaultCallbackReadinessTimeout
+	if j.callbackReadinessTimeout != nil {
+		timeout = *j.callbackReadinessTimeout
+	}
+	startedAt := time.Now()
+	for !j.isRunningAllContainers(pod.Status) {
+		if time.Since(startedAt) > timeout {
+			return nil, fmt.Errorf("job: containers of pod %s are not running after %s", pod.Name, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+		curPod, err := j.getPod(ctx, pod.Name)
+		if err != nil {
+			return nil, err
+		}
+		pod = curPod
+	}
+	return pod, nil
+}
+
+// SetLogBufferSize set the buffer size to read the container log.
+// If the line is longer than the buffer size, it is split into multiple ContainerLogs.
+func (j *Job) SetLogBufferSize(size int) {
+	j.logBufferSize = size
+}
+
+// SetLogChannelBuffer set the buffer size of the channel to deliver the container logs to the logger.
+// By default, the channel is unbuffered, so the slow logger blocks all log streams until it returns.
+// If the buffer is set, the log streams continue to read up to n logs while the logger is busy,
+// and block again when the buffer is full. All logs are still delivered in order per container.
+func (j *Job) SetLogChannelBuffer(n int) {
+	j.logChannelBuffer = n
+}
+
+// SetMetricsCollector set the collector of the metrics of the Job ( e.g. created count, outcome and duration ).
+// The metrics are not collected by default.
+func (j *Job) SetMetricsCollector(c MetricsCollector) {
+	j.metricsCollector = c
+}
+
+// SetLogDrainTimeout set the timeout to wait for all container logs to be delivered to the logger before Run returns.
+// By default, Run waits until all logs are delivered.
+func (j *Job) SetLogDrainTimeout(timeout time.Duration) {
+	j.logDrainTimeout = &timeout
+}
+
+// LogChannel returns the channel to receive the container logs.
+// If this is called before Run, logs are sent to the channel instead of the ContainerLogger,
+// and the channel is closed when Run is finished.
+// The channel must be consumed until it is closed, otherwise the log streaming is blocked.
+func (j *Job) LogChannel() <-chan *ContainerLog {
+	if j.logCh == nil {
+		j.logCh = make(chan *ContainerLog)
+	}
+	return j.logCh
+}
+
 func (j *Job) SetLogLevel(level LogLevel) {
 	j.logLevel = level
 }
@@ -100,6 +701,13 @@ func (j *Job) SetContainerLogger(logger ContainerLogger) {
 	j.containerLogger = logger
 }
 
+// SetContextLogger set the logger to receive the container logs with the context passed to Run.
+// This is useful to tag the logs with the values of the context ( e.g. correlation ID of the request ).
+// If this is set, the ContainerLogger is not used.
+func (j *Job) SetContextLogger(logger ContextLogger) {
+	j.contextLogger = logger
+}
+
 func (j *Job) SetLogger(logger Logger) {
 	j.logger = logger
 }
@@ -120,44 +728,181 @@ func (j *Job) DisableCommandLog() {
 	j.disabledCommandLog = true
 }
 
+// SetDeletePropagation set the propagation policy to delete the Job in the cleanup process.
+// By default, metav1.DeletePropagationBackground is used so that the pods are garbage-collected regardless of the cluster defaults.
+func (j *Job) SetDeletePropagation(propagation metav1.DeletionPropagation) {
+	j.deletePropagation = &propagation
+}
+
 func (j *Job) cleanup(ctx context.Context) error {
 	j.logDebug("cleanup job %s", j.Name)
-	errs := []error{}
+	errs := j.cleanupManifestResources(ctx)
+	errs = append(errs, j.deleteJob(ctx)...)
+	if len(errs) > 0 {
+		return errCleanup(j.Name, errs)
+	}
+	return nil
+}
+
+// deleteJob deletes the Job and its pods.
+func (j *Job) deleteJob(ctx context.Context) []error {
+	var errs []error
+	propagation := metav1.DeletePropagationBackground
+	if j.deletePropagation != nil {
+		propagation = *j.deletePropagation
+	}
 	if err := j.jobClient.Delete(ctx, j.Name, metav1.DeleteOptions{
 		GracePeriodSeconds: new(int64), // assign zero value as GracePeriodSeconds to delete immediately.
+		PropagationPolicy:  &propagation,
 	}); err != nil {
 		errs = append(errs, fmt.Errorf("failed to delete job: %w", err))
 	}
+	errs = append(errs, j.cleanupPods(ctx)...)
+	// kubejob's finalizer must be removed at last.
+	// the external finalizers still remain, so the Job is not removed until the external controllers remove them.
+	if j.hasFinalizer() {
+		if err := j.removeFinalizer(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove finalizer: %w", err))
+		}
+	}
+	return errs
+}
+
+func (j *Job) validatePriorityClass(ctx context.Context) error {
+	name := j.Job.Spec.Template.Spec.PriorityClassName
+	if name == "" {
+		return nil
+	}
+	if _, err := j.priorityClassClient.Get(ctx, name, metav1.GetOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return errPriorityClassNotFound(name)
+		}
+		// the priority class is cluster-scoped resource, so it may not be permitted to get it.
+		// in this case, leave the validation to the API server.
+		j.logWarn("failed to validate priority class %s: %s", name, err)
+	}
+	return nil
+}
+
+func (j *Job) cleanupPods(ctx context.Context) []error {
 	j.logDebug("search by %s", j.labelSelector())
 	podList, err := j.podClient.List(ctx, metav1.ListOptions{
 		LabelSelector: j.labelSelector(),
 	})
 	if err != nil {
-		errs = append(errs, fmt.Errorf("failed to list pod: %w", err))
+		return []error{fmt.Errorf("failed to list pod: %w", err)}
 	}
-	if podList == nil || len(podList.Items) == 0 {
+	if len(podList.Items) == 0 {
 		j.logWarn("could not find pod to remove")
-		if len(errs) > 0 {
-			return errCleanup(j.Name, errs)
-		}
 		return nil
 	}
 	j.logDebug("%d pods found", len(podList.Items))
+	var errs []error
 	for _, pod := range podList.Items {
+		if !j.isOwnedPod(pod) {
+			j.logDebug("skip to delete pod %s because it is not owned by job %s", pod.Name, j.Name)
+			continue
+		}
 		j.logDebug("delete pod: %s job-id: %s", pod.Name, pod.Labels[SelectorLabel])
 		if err := j.podClient.Delete(ctx, pod.Name, metav1.DeleteOptions{
 			GracePeriodSeconds: new(int64), // assign zero value as GracePeriodSeconds to delete immediately.
 		}); err != nil {
+			if apierrors.IsNotFound(err) {
+				// already deleted when the deadline of the context exceeded.
+				continue
+			}
 			errs = append(errs, fmt.Errorf("failed to delete pod %s: %w", pod.Name, err))
 		}
 	}
-	if len(errs) > 0 {
-		return errCleanup(j.Name, errs)
+	return errs
+}
+
+// isOwnedPod returns true if the pod is owned by the created Job.
+// The label selector may match the pods of the other Job if the user overrides the label,
+// so check the owner reference to avoid deleting unrelated pods.
+func (j *Job) isOwnedPod(pod corev1.Pod) bool {
+	if j.createdJob == nil || j.createdJob.UID == "" {
+		return true
 	}
-	return nil
+	for _, ref := range pod.OwnerReferences {
+		if ref.UID == j.createdJob.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// isPodOfOtherJob returns true if the pod is explicitly owned by the other Job.
+// Unlike isOwnedPod, the pod that has no owner reference of the Job is not treated as the other Job's one.
+func (j *Job) isPodOfOtherJob(pod *corev1.Pod) bool {
+	if j.createdJob == nil || j.createdJob.UID == "" {
+		return false
+	}
+	ownedByJob := false
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind != "Job" {
+			continue
+		}
+		if ref.UID == j.createdJob.UID {
+			return false
+		}
+		ownedByJob = true
+	}
+	return ownedByJob
+}
+
+// SetFinalizers set the finalizers to the Job.
+// This is useful when the external controller wants to observe the Job after it is finished.
+// kubejob also adds its own finalizer ( JobFinalizer ) and removes it at last of the cleanup process,
+// so the Job is removed after the external controllers remove the specified finalizers.
+// This must be called before Run.
+func (j *Job) SetFinalizers(finalizers []string) {
+	j.Job.Finalizers = append(append([]string{}, finalizers...), JobFinalizer)
+}
+
+func (j *Job) hasFinalizer() bool {
+	for _, finalizer := range j.Job.Finalizers {
+		if finalizer == JobFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *Job) removeFinalizer(ctx context.Context) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		job, err := j.jobClient.Get(ctx, j.Name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		finalizers := make([]string, 0, len(job.Finalizers))
+		for _, finalizer := range job.Finalizers {
+			if finalizer == JobFinalizer {
+				continue
+			}
+			finalizers = append(finalizers, finalizer)
+		}
+		job.Finalizers = finalizers
+		_, err = j.jobClient.Update(ctx, job, metav1.UpdateOptions{})
+		return err
+	})
 }
 
 func (j *Job) Run(ctx context.Context) (e error) {
+	startedAt := time.Now()
+	// the log channel is closed by the log consumer after it is started,
+	// so that the channel is never closed while sending the log.
+	startedLogConsumer := false
+	defer func() {
+		if j.logCh != nil && !startedLogConsumer {
+			close(j.logCh)
+		}
+	}()
+	// unblock WaitForLogPattern for the containers whose log stream was never started.
+	defer j.finishLogPattern("")
 	if j.jobInit != nil {
 		if err := j.setupInitContainers(); err != nil {
 			return err
@@ -168,6 +913,7 @@ func (j *Job) Run(ctx context.Context) (e error) {
 			j.jobInit.executedContainerNameMap[j.preInit.container.Name] = struct{}{}
 		}
 	}
+	j.setupStdinFile()
 	if j.preInit != nil {
 		if err := j.setupPreInitContainer(); err != nil {
 			return err
@@ -175,11 +921,41 @@ func (j *Job) Run(ctx context.Context) (e error) {
 		initContainers := j.Job.Spec.Template.Spec.InitContainers
 		j.Job.Spec.Template.Spec.InitContainers = append([]corev1.Container{j.preInit.container}, initContainers...)
 	}
-	job, err := j.jobClient.Create(ctx, j.Job, metav1.CreateOptions{})
+	j.setupAgentServiceAccountToken()
+	if err := j.validatePriorityClass(ctx); err != nil {
+		return err
+	}
+	if err := j.checkConcurrentRun(ctx); err != nil {
+		return err
+	}
+	if err := j.createManifestResources(ctx); err != nil {
+		if errs := j.cleanupManifestResources(context.Background()); len(errs) > 0 {
+			return errCleanup(j.Name, append([]error{err}, errs...))
+		}
+		return err
+	}
+	job, err := j.createJob(ctx)
 	if err != nil {
-		return errJobCreation(j.Name, j.GenerateName, err)
+		createErr := errJobCreation(j.Name, j.GenerateName, err)
+		if namespace, ok := j.notFoundNamespace(err); ok {
+			createErr = errNamespaceNotFound(namespace, err)
+		}
+		if errs := j.cleanupManifestResources(context.Background()); len(errs) > 0 {
+			return errCleanup(j.Name, append([]error{createErr}, errs...))
+		}
+		return createErr
 	}
 	j.Name = job.Name
+	j.createdJob = job
+	if j.createdHandler != nil {
+		j.createdHandler(job)
+	}
+	if j.metricsCollector != nil {
+		j.metricsCollector.JobCreated(job.Namespace)
+		defer func() {
+			j.metricsCollector.JobFinished(job.Namespace, jobOutcome(ctx, e), time.Since(startedAt))
+		}()
+	}
 	defer func() {
 		// we wouldn't like to cancel cleanup process by cancelled context,
 		// so create new context and use it.
@@ -192,32 +968,224 @@ func (j *Job) Run(ctx context.Context) (e error) {
 		}
 	}()
 
-	j.containerLogs = make(chan *ContainerLog)
+	j.containerLogs = make(chan *ContainerLog, j.logChannelBuffer)
+	j.logStreamMu.Lock()
+	j.logStreamClosed = false
+	j.logStreamMu.Unlock()
+	logDone := make(chan struct{})
+	startedLogConsumer = true
 	go func() {
+		defer close(logDone)
+		if j.logCh != nil {
+			defer close(j.logCh)
+		}
 		for containerLog := range j.containerLogs {
-			j.containerLog(containerLog)
+			if j.logCh != nil {
+				select {
+				case <-ctx.Done():
+				case j.logCh <- containerLog:
+				}
+			} else {
+				j.containerLog(ctx, containerLog)
+			}
+		}
+	}()
+	defer func() {
+		drained := make(chan struct{})
+		go func() {
+			defer close(drained)
+			// the watch loop may still be running ( e.g. the execution handler ignores the cancelled context ),
+			// so reject the new log streams before waiting for the running ones.
+			// After that, all senders have been finished, so we can close the channel safely
+			// and wait for the consumer to drain the remaining logs.
+			j.logStreamMu.Lock()
+			j.logStreamClosed = true
+			j.logStreamMu.Unlock()
+			j.logStreamWG.Wait()
+			close(j.containerLogs)
+			<-logDone
+		}()
+		if j.logDrainTimeout == nil {
+			<-drained
+			return
+		}
+		select {
+		case <-drained:
+		case <-time.After(*j.logDrainTimeout):
+			j.logWarn("timeout to drain the container logs. the remaining logs may be lost")
 		}
 	}()
 
 	errCh := make(chan error, 1)
 	go func() {
-		errCh <- j.wait(ctx)
+		errCh <- j.waitWithEvictionRetry(ctx)
 	}()
 	select {
 	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			// delete the running pods immediately instead of waiting for the watch loop to stop and the Job to be deleted.
+			for _, err := range j.cleanupPods(context.Background()) {
+				j.logWarn("%s", err)
+			}
+			return fmt.Errorf("job: %s exceeded the deadline of the context: %w", j.Name, ctx.Err())
+		}
+		// don't wait for the watch loop to stop because the execution handler may not stop by the cancelled context.
+		// the log streams are stopped by the cancelled context and the new ones are rejected.
 		return nil
 	case err := <-errCh:
 		return err
 	}
-	return nil
+	return nil
+}
+
+// RunAndCollectLogs runs the Job and returns the full logs of all containers ( including init containers ) by the container name.
+// The logs are collected after the streams are drained, so the logs of the container that completes quickly are not lost.
+// The command logs are not included, and the logger set by SetContainerLogger or SetContextLogger is still called.
+// The loggers and the command log settings are restored after the Job is finished.
+// If LogChannel is used, the logs are sent to the channel instead, so nothing is collected.
+func (j *Job) RunAndCollectLogs(ctx context.Context) (map[string][]byte, error) {
+	var (
+		mu   sync.Mutex
+		logs = map[string][]byte{}
+	)
+	containerLogger := j.containerLogger
+	contextLogger := j.contextLogger
+	disabledInitCommandLog := j.disabledInitCommandLog
+	disabledCommandLog := j.disabledCommandLog
+	defer func() {
+		j.contextLogger = contextLogger
+		j.disabledInitCommandLog = disabledInitCommandLog
+		j.disabledCommandLog = disabledCommandLog
+	}()
+	j.DisableInitCommandLog()
+	j.DisableCommandLog()
+	j.contextLogger = func(ctx context.Context, log *ContainerLog) {
+		mu.Lock()
+		logs[log.Container.Name] = append(logs[log.Container.Name], log.Log...)
+		mu.Unlock()
+		if contextLogger != nil {
+			contextLogger(ctx, log)
+		} else if containerLogger != nil {
+			containerLogger(log)
+		}
+	}
+	err := j.Run(ctx)
+	mu.Lock()
+	defer mu.Unlock()
+	return logs, err
+}
+
+func (j *Job) sendContainerLog(ctx context.Context, log *ContainerLog) {
+	select {
+	case <-ctx.Done():
+	case j.containerLogs <- log:
+	}
+}
+
+func (j *Job) containerLog(ctx context.Context, log *ContainerLog) {
+	if j.contextLogger != nil {
+		j.contextLogger(ctx, log)
+	} else if j.containerLogger != nil {
+		j.containerLogger(log)
+	} else if !log.IsFinished {
+		if index, exists := log.CompletionIndex(); exists {
+			fmt.Fprintf(j.stderrWriter(), "[%s] %s", index, log.Log)
+		} else {
+			fmt.Fprintf(j.stderrWriter(), "%s", log.Log)
+		}
+	}
+}
+
+// SetOS set the operating system of the containers.
+// If OSWindows is specified, kubejob controls the containers by PowerShell instead of sh
+// and uses C:\Windows\Temp instead of /tmp to put the status file.
+// By default, the operating system is detected by the kubernetes.io/os nodeSelector of the pod, and OSLinux is used if it isn't specified.
+func (j *Job) SetOS(os OS) {
+	j.os = os
+}
+
+func (j *Job) targetOS() OS {
+	if j.os != "" {
+		return j.os
+	}
+	if OS(j.Job.Spec.Template.Spec.NodeSelector[corev1.LabelOSStable]) == OSWindows {
+		return OSWindows
+	}
+	return OSLinux
+}
+
+// jobExecutionWrapper returns the wrapper to control the containers by the execution handler.
+// If the wrapper isn't specified by SetExecutionWrapper, returns the wrapper for the target OS.
+func (j *Job) jobExecutionWrapper() ExecutionWrapper {
+	if j.executionWrapper != nil {
+		return j.executionWrapper
+	}
+	if j.targetOS() == OSWindows {
+		return windowsExecutionWrapper
+	}
+	return nil
+}
+
+func (j *Job) statusFilePath() string {
+	if j.targetOS() == OSWindows {
+		return windowsJobStatusFilePath
+	}
+	return jobStatusFilePath
+}
+
+func (j *Job) stdoutFilePath() string {
+	if j.targetOS() == OSWindows {
+		return windowsJobStdoutFilePath
+	}
+	return jobStdoutFilePath
+}
+
+func (j *Job) stderrFilePath() string {
+	if j.targetOS() == OSWindows {
+		return windowsJobStderrFilePath
+	}
+	return jobStderrFilePath
+}
+
+// SetStdout set the writer to write the executed commands instead of os.Stdout.
+// If SetCommandLogWriter is used, the commands are written to it instead.
+func (j *Job) SetStdout(w io.Writer) {
+	j.stdout = w
+}
+
+// SetStderr set the writer to write the container logs and the diagnostic messages by default instead of os.Stderr.
+// If ContainerLogger or Logger is set, it takes precedence over this.
+func (j *Job) SetStderr(w io.Writer) {
+	j.stderr = w
+}
+
+// SetCommandLogWriter set the writer to write the commands executed by the executors.
+// By default, the commands are written to the writer specified by SetStdout ( os.Stdout ),
+// so use this to style or suppress them independently from the output.
+func (j *Job) SetCommandLogWriter(w io.Writer) {
+	j.commandLogWriter = w
+}
+
+func (j *Job) writeCommandLog(cmd []string) {
+	w := j.commandLogWriter
+	if w == nil {
+		w = j.stdoutWriter()
+	}
+	fmt.Fprintln(w, strings.Join(cmd, " "))
+}
+
+func (j *Job) stdoutWriter() io.Writer {
+	if j.stdout != nil {
+		return j.stdout
+	}
+	return os.Stdout
 }
 
-func (j *Job) containerLog(log *ContainerLog) {
-	if j.containerLogger != nil {
-		j.containerLogger(log)
-	} else if !log.IsFinished {
-		fmt.Fprintf(os.Stderr, "%s", log.Log)
+func (j *Job) stderrWriter() io.Writer {
+	if j.stderr != nil {
+		return j.stderr
 	}
+	return os.Stderr
 }
 
 func (j *Job) logWarn(format string, args ...interface{}) {
@@ -242,7 +1210,7 @@ func (j *Job) logf(format string, args ...interface{}) {
 	if j.logger != nil {
 		j.logger(log)
 	} else {
-		fmt.Fprintf(os.Stderr, "%s\n", log)
+		fmt.Fprintf(j.stderrWriter(), "%s\n", log)
 	}
 }
 
@@ -254,26 +1222,294 @@ func (j *Job) getPod(ctx context.Context, name string) (*corev1.Pod, error) {
 	return pod, nil
 }
 
-func (j *Job) wait(ctx context.Context) error {
-	watcher, err := j.podClient.Watch(ctx, metav1.ListOptions{
-		LabelSelector: j.labelSelector(),
-		Watch:         true,
-	})
+func (j *Job) watchPod(ctx context.Context) (watch.Interface, error) {
+	opts := metav1.ListOptions{
+		LabelSelector:   j.labelSelector(),
+		Watch:           true,
+		ResourceVersion: j.lastResourceVersion,
+	}
+	if j.watchTimeout != nil {
+		timeoutSeconds := int64(j.watchTimeout.Seconds())
+		opts.TimeoutSeconds = &timeoutSeconds
+	}
+	watcher, err := j.podClient.Watch(ctx, opts)
 	if err != nil {
-		return errJobWatch(j.Name, err)
+		return nil, errJobWatch(j.Name, err)
 	}
-	defer watcher.Stop()
+	return watcher, nil
+}
 
+func (j *Job) wait(ctx context.Context) error {
+	watcher, err := j.watchPod(ctx)
+	if err != nil {
+		return err
+	}
 	if err := j.watchLoop(ctx, watcher); err != nil {
 		return err
 	}
 	return nil
 }
 
+// SetRetryOnEviction set the number of times to recreate the Job when the pod is evicted ( e.g. by the node pressure ).
+// The evicted Job is deleted and the same Job is created again,
+// so the preinit callback, the init container execution handler and the execution handler are also called again for the new pod.
+// If the pod is still evicted after the retries, Run returns *PodEvictedError.
+func (j *Job) SetRetryOnEviction(count int) {
+	j.evictionRetryCount = count
+}
+
+func (j *Job) waitWithEvictionRetry(ctx context.Context) error {
+	for retryCount := 0; ; retryCount++ {
+		err := j.wait(ctx)
+		var evictedErr *PodEvictedError
+		if !errors.As(err, &evictedErr) || retryCount >= j.evictionRetryCount {
+			return err
+		}
+		j.logWarn("%s. retry: %d/%d", evictedErr, retryCount+1, j.evictionRetryCount)
+		if err := j.recreateJob(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// recreateJob deletes the current Job and creates the same Job again.
+func (j *Job) recreateJob(ctx context.Context) error {
+	if errs := j.deleteJob(ctx); len(errs) > 0 {
+		return errCleanup(j.Name, errs)
+	}
+	if j.GenerateName != "" {
+		j.Job.Name = ""
+	} else if err := j.waitForJobDeletion(ctx); err != nil {
+		return err
+	}
+	// don't resume the watch of the previous Job.
+	j.lastResourceVersion = ""
+	j.resetExecution()
+	job, err := j.createJob(ctx)
+	if err != nil {
+		return errJobCreation(j.Name, j.GenerateName, err)
+	}
+	j.Name = job.Name
+	j.createdJob = job
+	if j.createdHandler != nil {
+		j.createdHandler(job)
+	}
+	return nil
+}
+
+// resetExecution clears the state of the handlers executed for the previous pod.
+func (j *Job) resetExecution() {
+	j.preInit.reset()
+	j.jobInit.reset()
+	if j.failFast != nil {
+		j.failFast.reset()
+	}
+	if j.resetExecutionHandler != nil {
+		j.resetExecutionHandler()
+	}
+}
+
+// waitForJobDeletion waits until the Job is deleted to create the Job with the same name.
+func (j *Job) waitForJobDeletion(ctx context.Context) error {
+	for {
+		if _, err := j.jobClient.Get(ctx, j.Name, metav1.GetOptions{}); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+func (j *Job) evictedError(pod *corev1.Pod) error {
+	if pod.Status.Reason != podEvictedReason {
+		return nil
+	}
+	return errPodEvicted(pod, pod.Status.Message)
+}
+
 func (j *Job) labelSelector() string {
+	if j.trackingLabelKey != "" {
+		return fmt.Sprintf("%s=%s", j.trackingLabelKey, j.Spec.Template.Labels[j.trackingLabelKey])
+	}
 	return fmt.Sprintf("%s=%s", SelectorLabel, j.Spec.Template.Labels[SelectorLabel])
 }
 
+// SetTrackingLabel set the label used to select the pod instead of the generated SelectorLabel.
+// The label is added to the pod template, so external systems can watch for the pod before the Job is created.
+// The value must be unique across the jobs in the namespace, otherwise the pods of other jobs are also selected.
+func (j *Job) SetTrackingLabel(key, value string) error {
+	errs := validation.IsQualifiedName(key)
+	errs = append(errs, validation.IsValidLabelValue(value)...)
+	if value == "" {
+		errs = append(errs, "tracking label value must not be empty")
+	}
+	if len(errs) > 0 {
+		return errInvalidLabel(key, value, errs)
+	}
+	if j.Spec.Template.Labels == nil {
+		j.Spec.Template.Labels = map[string]string{}
+	}
+	j.Spec.Template.Labels[key] = value
+	j.trackingLabelKey = key
+	return nil
+}
+
+// notifyRestarts calls the restart handler for the containers whose restart count increased since the last observation.
+// restartCounts is keyed by the pod name and the container name because the restart count is reset in the new pod.
+func (j *Job) notifyRestarts(pod *corev1.Pod, restartCounts map[string]int32) {
+	if j.restartHandler == nil {
+		return
+	}
+	for _, status := range append(
+		append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+		pod.Status.ContainerStatuses...,
+	) {
+		key := pod.Name + "/" + status.Name
+		if status.RestartCount <= restartCounts[key] {
+			continue
+		}
+		restartCounts[key] = status.RestartCount
+		j.restartHandler(status.Name, status.RestartCount)
+	}
+}
+
+func (j *Job) isRestartPolicyOnFailure() bool {
+	return j.Job.Spec.Template.Spec.RestartPolicy == corev1.RestartPolicyOnFailure
+}
+
+// checkJobCompletion checks the conditions of the Job and returns true if the Job is finished.
+// If the Job is failed ( e.g. BackoffLimit is exhausted ), returns FailedJob as the error.
+func (j *Job) checkJobCompletion(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	job, err := j.jobClient.Get(ctx, j.Name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, errJobDeleted(j.Name, pod)
+		}
+		return true, errJobWatch(j.Name, err)
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			if condition.Reason == jobDeadlineExceededReason {
+				return true, errDeadlineExceeded(j.Name, pod, j.activeDeadline(), condition.Message)
+			}
+			return true, &FailedJob{
+				Pod:    pod,
+				Reason: fmt.Errorf("job: %s: %s", condition.Reason, condition.Message),
+			}
+		}
+	}
+	return false, nil
+}
+
+// jobDeadlineExceededReason is the reason of the JobFailed condition when the Job exceeded ActiveDeadlineSeconds.
+const jobDeadlineExceededReason = "DeadlineExceeded"
+
+// deadlineConditionRetryCount is the number of times to check the conditions of the Job after the pod was terminated.
+// The Job controller terminates the pods before it updates the conditions, so the condition may not be set yet.
+const deadlineConditionRetryCount = 5
+
+func (j *Job) activeDeadline() time.Duration {
+	if j.Spec.ActiveDeadlineSeconds == nil {
+		return 0
+	}
+	return time.Duration(*j.Spec.ActiveDeadlineSeconds) * time.Second
+}
+
+// deadlineExceededError returns DeadlineExceededError if the Job was terminated by exceeding ActiveDeadlineSeconds.
+func (j *Job) deadlineExceededError(ctx context.Context, pod *corev1.Pod) error {
+	deadline := j.activeDeadline()
+	if deadline == 0 {
+		return nil
+	}
+	if j.createdJob != nil && time.Since(j.createdJob.CreationTimestamp.Time) < deadline {
+		return nil
+	}
+	for i := 0; i < deadlineConditionRetryCount; i++ {
+		job, err := j.jobClient.Get(ctx, j.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil
+		}
+		for _, condition := range job.Status.Conditions {
+			if condition.Type != batchv1.JobFailed || condition.Status != corev1.ConditionTrue {
+				continue
+			}
+			if condition.Reason == jobDeadlineExceededReason {
+				return errDeadlineExceeded(j.Name, pod, deadline, condition.Message)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(1 * time.Second):
+		}
+	}
+	return nil
+}
+
+// execFormatErrorMessage is the message when the binary of the image cannot be executed on the node
+// ( e.g. arm64 image is running on amd64 node ).
+const execFormatErrorMessage = "exec format error"
+
+func (j *Job) archMismatchError(pod *corev1.Pod) error {
+	statuses := append(
+		append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+		pod.Status.ContainerStatuses...,
+	)
+	for _, status := range statuses {
+		terminated := status.State.Terminated
+		if terminated == nil {
+			continue
+		}
+		if strings.Contains(terminated.Message, execFormatErrorMessage) {
+			return errArchMismatch(pod, status.Name, terminated.Message)
+		}
+	}
+	return nil
+}
+
+const (
+	oomKilledReason  = "OOMKilled"
+	podEvictedReason = "Evicted"
+)
+
+func (j *Job) oomKilledError(pod *corev1.Pod) error {
+	containers := append(
+		append([]corev1.Container{}, pod.Spec.InitContainers...),
+		pod.Spec.Containers...,
+	)
+	statuses := append(
+		append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+		pod.Status.ContainerStatuses...,
+	)
+	for _, status := range statuses {
+		terminated := status.State.Terminated
+		if terminated == nil || terminated.Reason != oomKilledReason {
+			continue
+		}
+		var limit resource.Quantity
+		for _, c := range containers {
+			if c.Name == status.Name {
+				limit = c.Resources.Limits[corev1.ResourceMemory]
+			}
+		}
+		return errOOMKilled(pod, status.Name, limit)
+	}
+	return nil
+}
+
 func (j *Job) isPodInitializing(pod *corev1.Pod) bool {
 	const waitingReasonPodInitializing = "PodInitializing"
 
@@ -291,7 +1527,7 @@ func (j *Job) isPodInitializing(pod *corev1.Pod) bool {
 }
 
 func (j *Job) watchPodPendingPhase(ctx context.Context, name string) error {
-	if j.pendingTimeout == nil {
+	if j.pendingTimeout == nil && j.podPendingTimeout == nil {
 		return nil
 	}
 
@@ -312,7 +1548,13 @@ func (j *Job) watchPodPendingPhase(ctx context.Context, name string) error {
 		case corev1.PodRunning, corev1.PodSucceeded, corev1.PodFailed:
 			return nil
 		}
-		if j.isPodInitializing(curPod) {
+		if j.podPendingTimeout != nil {
+			createdAt := curPod.CreationTimestamp.Time
+			if *j.podPendingTimeout < time.Since(createdAt) {
+				return errPendingTimeout(createdAt, *j.podPendingTimeout)
+			}
+		}
+		if j.pendingTimeout != nil && j.isPodInitializing(curPod) {
 			onceForPodInitializing.Do(func() {
 				startedPodInitializing = time.Now()
 			})
@@ -334,29 +1576,116 @@ func (j *Job) isReadyAllContainers(status corev1.PodStatus) bool {
 	return true
 }
 
+// watchedPod is the state of the pod observed by the watch loop.
+// If the Job runs multiple pods ( e.g. parallelism is greater than 1 ), each pod is tracked separately.
+type watchedPod struct {
+	phase corev1.PodPhase
+	once  sync.Once
+}
+
 func (j *Job) watchLoop(ctx context.Context, watcher watch.Interface) (e error) {
 	var (
 		eg                    errgroup.Group
-		once                  sync.Once
 		onceWatchPendingPhase sync.Once
+		onceSidecarShutdown   sync.Once
+		onceWaitJobCompletion sync.Once
+		watchedPods           = map[string]*watchedPod{}
+		restartCounts         = map[string]int32{}
+		jobCompletionErrCh    = make(chan error, 1)
 	)
+	// pendingPhaseErrCh receives the timeout error while the pod is in the Pending phase.
+	// In this case, the watch loop should be stopped because the pod phase may never change.
+	pendingPhaseErrCh := make(chan error, 1)
 	eg.Go(func() error {
-		var phase corev1.PodPhase
-		for event := range watcher.ResultChan() {
+		defer func() {
+			watcher.Stop()
+		}()
+		for {
+			var event watch.Event
+			select {
+			case <-ctx.Done():
+				// the watcher may not be closed by the cancelled context ( e.g. fake client ),
+				// so we should stop the watch loop explicitly.
+				return nil
+			case err := <-pendingPhaseErrCh:
+				return err
+			case err := <-jobCompletionErrCh:
+				return err
+			case ev, ok := <-watcher.ResultChan():
+				if !ok {
+					if ctx.Err() != nil {
+						return nil
+					}
+					// the watch was closed by the server ( e.g. timeout ).
+					// resume it from the last seen resourceVersion so as not to miss or replay events.
+					j.logDebug("resume watch from resourceVersion %s", j.lastResourceVersion)
+					watcher.Stop()
+					newWatcher, err := j.watchPod(ctx)
+					if err != nil {
+						return err
+					}
+					watcher = newWatcher
+					continue
+				}
+				event = ev
+			}
+			if event.Type == watch.Error {
+				err := apierrors.FromObject(event.Object)
+				if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+					// the last seen resourceVersion is too old, so re-establish the watch from the latest state.
+					j.logDebug("resourceVersion %s is expired. re-establish watch", j.lastResourceVersion)
+					j.lastResourceVersion = ""
+					watcher.Stop()
+					newWatcher, err := j.watchPod(ctx)
+					if err != nil {
+						return err
+					}
+					watcher = newWatcher
+					continue
+				}
+				return errJobWatch(j.Name, err)
+			}
 			pod, ok := event.Object.(*corev1.Pod)
 			if !ok {
 				// if event.Object will be not corev1.Pod, we expect that it was executed cancel to the context.Context.
 				// In this case, we should stop watch loop, so return instantly.
 				return nil
 			}
+			if j.isPodOfOtherJob(pod) {
+				// the pod of the previous Job that has the same label ( e.g. recreated by the eviction ).
+				continue
+			}
+			j.lastResourceVersion = pod.ResourceVersion
+			j.setLastPod(pod)
+			j.sendStatus(ctx, pod)
+			j.notifyRestarts(pod, restartCounts)
+			if event.Type == watch.Deleted {
+				if j.isRestartPolicyOnFailure() || j.isParallelismPatched() {
+					// the pod may be deleted by the Job controller when the BackoffLimit is exhausted or the parallelism is scaled down.
+					// in this case, the Job still exists, so the deletion of the Job is detected by its conditions.
+					done, err := j.checkJobCompletion(ctx, pod)
+					if done {
+						return err
+					}
+					continue
+				}
+				// the pod is deleted by the Job controller when the Job exceeded the active deadline.
+				if err := j.deadlineExceededError(ctx, pod); err != nil {
+					return err
+				}
+				// the pod was deleted by others ( e.g. administrator or TTL controller ) while watching.
+				return errJobDeleted(j.Name, pod)
+			}
 			onceWatchPendingPhase.Do(func() {
 				name := pod.Name
-				eg.Go(func() error {
-					return j.watchPodPendingPhase(ctx, name)
-				})
+				go func() {
+					if err := j.watchPodPendingPhase(ctx, name); err != nil {
+						pendingPhaseErrCh <- err
+					}
+				}()
 			})
 			if j.preInit.needsToRun(pod.Status) {
-				if err := j.preInit.run(pod); err != nil {
+				if err := j.preInit.run(ctx, pod); err != nil {
 					return err
 				}
 			}
@@ -368,7 +1697,22 @@ func (j *Job) watchLoop(ctx context.Context, watcher watch.Interface) (e error)
 					return err
 				}
 			}
-			if pod.Status.Phase == phase {
+			if j.sidecarShutdown && pod.Status.Phase == corev1.PodRunning && j.isFinishedMainContainers(pod) {
+				onceSidecarShutdown.Do(func() {
+					eg.Go(func() error {
+						if err := j.shutdownSidecars(ctx, pod); err != nil {
+							j.logWarn("%s", err)
+						}
+						return nil
+					})
+				})
+			}
+			watched, exists := watchedPods[pod.Name]
+			if !exists {
+				watched = &watchedPod{}
+				watchedPods[pod.Name] = watched
+			}
+			if pod.Status.Phase == watched.phase {
 				continue
 			}
 			switch pod.Status.Phase {
@@ -382,13 +1726,17 @@ func (j *Job) watchLoop(ctx context.Context, watcher watch.Interface) (e error)
 				if !j.isReadyAllContainers(pod.Status) {
 					continue
 				}
-				once.Do(func() {
+				watched.once.Do(func() {
 					eg.Go(func() error {
 						if err := j.logStreamInitContainers(ctx, pod); err != nil {
 							return err
 						}
 						if j.podRunningCallback != nil {
-							if err := j.podRunningCallback(pod); err != nil {
+							runningPod, err := j.waitForRunningAllContainers(ctx, pod)
+							if err != nil {
+								return err
+							}
+							if err := j.podRunningCallback(runningPod); err != nil {
 								return err
 							}
 						} else {
@@ -400,7 +1748,7 @@ func (j *Job) watchLoop(ctx context.Context, watcher watch.Interface) (e error)
 					})
 				})
 			case corev1.PodSucceeded, corev1.PodFailed:
-				once.Do(func() {
+				watched.once.Do(func() {
 					eg.Go(func() error {
 						if err := j.logStreamInitContainers(ctx, pod); err != nil {
 							return err
@@ -414,13 +1762,44 @@ func (j *Job) watchLoop(ctx context.Context, watcher watch.Interface) (e error)
 					})
 				})
 				if pod.Status.Phase == corev1.PodFailed {
+					if err := j.archMismatchError(pod); err != nil {
+						return err
+					}
+					if err := j.evictedError(pod); err != nil {
+						return err
+					}
+					if err := j.oomKilledError(pod); err != nil {
+						return err
+					}
+					if err := j.deadlineExceededError(ctx, pod); err != nil {
+						return err
+					}
+					if j.isRestartPolicyOnFailure() {
+						// the failed pod is retried until the BackoffLimit is exhausted,
+						// so the completion of the Job is determined by its conditions.
+						done, err := j.checkJobCompletion(ctx, pod)
+						if done {
+							return err
+						}
+						continue
+					}
 					return &FailedJob{Pod: pod}
 				}
+				if j.isParallel() {
+					// the other pods of the Job may be still running,
+					// so wait for the completion of the Job instead of returning instantly.
+					onceWaitJobCompletion.Do(func() {
+						go func() {
+							jobCompletionErrCh <- j.waitForJobCompletion(ctx, pod)
+						}()
+					})
+					watched.phase = pod.Status.Phase
+					continue
+				}
 				return nil
 			}
-			phase = pod.Status.Phase
+			watched.phase = pod.Status.Phase
 		}
-		return nil
 	})
 	if err := eg.Wait(); err != nil {
 		return err
@@ -459,8 +1838,28 @@ func (j *Job) commandLog(pod *corev1.Pod, container corev1.Container) *Container
 	}
 }
 
+// SetLogContainers restricts the containers ( and init containers ) to stream logs.
+// The logs of the unlisted containers are not streamed at all.
+func (j *Job) SetLogContainers(names ...string) {
+	j.logContainerNameMap = map[string]struct{}{}
+	for _, name := range names {
+		j.logContainerNameMap[name] = struct{}{}
+	}
+}
+
+func (j *Job) isLogContainer(name string) bool {
+	if j.logContainerNameMap == nil {
+		return true
+	}
+	_, exists := j.logContainerNameMap[name]
+	return exists
+}
+
 func (j *Job) logStreamInitContainers(ctx context.Context, pod *corev1.Pod) error {
 	for _, container := range pod.Spec.InitContainers {
+		if !j.isLogContainer(container.Name) {
+			continue
+		}
 		enabledLog := !j.disabledInitContainerLog
 		if err := j.logStreamContainer(
 			ctx,
@@ -479,6 +1878,9 @@ func (j *Job) logStreamPod(ctx context.Context, pod *corev1.Pod) error {
 	var eg errgroup.Group
 	for _, container := range pod.Spec.Containers {
 		container := container
+		if !j.isLogContainer(container.Name) {
+			continue
+		}
 		eg.Go(func() error {
 			enabledLog := !j.disabledContainerLog
 			if err := j.logStreamContainer(
@@ -499,53 +1901,210 @@ func (j *Job) logStreamPod(ctx context.Context, pod *corev1.Pod) error {
 	return nil
 }
 
-func (j *Job) logStreamContainer(ctx context.Context, pod *corev1.Pod, container corev1.Container, enabledCommandLog, enabledLog bool) error {
-	stream, err := j.restClient.Get().
+func (j *Job) openLogStream(ctx context.Context, pod *corev1.Pod, container corev1.Container) (io.ReadCloser, error) {
+	return j.openLogStreamWithFollow(ctx, pod, container, true)
+}
+
+func (j *Job) openLogStreamWithFollow(ctx context.Context, pod *corev1.Pod, container corev1.Container, follow bool) (io.ReadCloser, error) {
+	return j.restClient.Get().
 		Namespace(pod.Namespace).
 		Resource("pods").
 		Name(pod.Name).
 		SubResource("log").
 		VersionedParams(&corev1.PodLogOptions{
-			Follow:    true,
+			Follow:    follow,
 			Container: container.Name,
 		}, scheme.ParameterCodec).Stream(ctx)
+}
+
+// readLogsAfterCompletion reads the whole logs of the container without following.
+// If the pod completes before the log stream is opened, the follow stream may read nothing.
+// In this case, the logs are still available after the completion, so read them again.
+func (j *Job) readLogsAfterCompletion(ctx context.Context, pod *corev1.Pod, container corev1.Container, enabledLog bool) error {
+	current, err := j.getPod(ctx, pod.Name)
+	if err != nil {
+		return err
+	}
+	if current.Status.Phase != corev1.PodSucceeded {
+		return nil
+	}
+	j.logDebug("read logs of %s again because the log stream was empty", container.Name)
+	stream, err := j.openLogStreamWithFollow(ctx, pod, container, false)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	if _, err := j.readLogStream(ctx, stream, pod, container, enabledLog); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (j *Job) containerRestartCount(pod *corev1.Pod, containerName string) int32 {
+	for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if status.Name == containerName {
+			return status.RestartCount
+		}
+	}
+	return 0
+}
+
+// waitForContainerRestart waits for the container to be restarted after the log stream reached EOF.
+// If the container will not be restarted, returns false.
+func (j *Job) waitForContainerRestart(ctx context.Context, podName, containerName string, restartCount int32) (bool, error) {
+	for {
+		pod, err := j.getPod(ctx, podName)
+		if err != nil {
+			return false, err
+		}
+		switch pod.Status.Phase {
+		case corev1.PodSucceeded, corev1.PodFailed:
+			return false, nil
+		}
+		if pod.Spec.RestartPolicy == corev1.RestartPolicyNever {
+			return false, nil
+		}
+		for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			if status.Name != containerName {
+				continue
+			}
+			if status.State.Running != nil && status.RestartCount > restartCount {
+				return true, nil
+			}
+			if status.State.Terminated != nil && status.State.Terminated.ExitCode == 0 {
+				return false, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// readLogStream reads the log stream until EOF and returns whether it read any content.
+func (j *Job) readLogStream(ctx context.Context, stream io.Reader, pod *corev1.Pod, container corev1.Container, enabledLog bool) (bool, error) {
+	var reader *bufio.Reader
+	if j.logBufferSize > 0 {
+		reader = bufio.NewReaderSize(stream, j.logBufferSize)
+	} else {
+		reader = bufio.NewReader(stream)
+	}
+	read := false
+	for {
+		// if the line is longer than the buffer, ReadSlice returns bufio.ErrBufferFull with the partial content.
+		// In this case, flush the partial content instead of waiting for the newline.
+		line, err := reader.ReadSlice('\n')
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return read, err
+		}
+		if len(line) > 0 {
+			read = true
+			j.matchLogPattern(container.Name, line)
+		}
+		if len(line) > 0 && enabledLog {
+			j.sendContainerLog(ctx, &ContainerLog{
+				Pod:       pod,
+				Container: container,
+				Log:       string(line),
+			})
+		}
+		if err == io.EOF {
+			return read, nil
+		}
+	}
+}
+
+// addLogStream registers the log stream that sends logs to the log channel.
+// If Run has already started to close the log channel, returns false.
+func (j *Job) addLogStream() bool {
+	j.logStreamMu.Lock()
+	defer j.logStreamMu.Unlock()
+	if j.logStreamClosed {
+		return false
+	}
+	j.logStreamWG.Add(1)
+	return true
+}
+
+func (j *Job) logStreamContainer(ctx context.Context, pod *corev1.Pod, container corev1.Container, enabledCommandLog, enabledLog bool) error {
+	// register the log stream before sending any logs so that the log channel is not closed while sending.
+	if !j.addLogStream() {
+		return nil
+	}
+	streaming := false
+	defer func() {
+		if !streaming {
+			j.logStreamWG.Done()
+		}
+	}()
+	stream, err := j.openLogStream(ctx, pod, container)
 	if err != nil {
 		return errLogStream(j.Name, pod, container, err)
 	}
 	defer stream.Close()
 
 	if enabledCommandLog {
-		j.containerLogs <- j.commandLog(pod, container)
+		j.sendContainerLog(ctx, j.commandLog(pod, container))
 	}
 
 	errchan := make(chan error, 1)
 
+	streaming = true
 	go func() {
-		reader := bufio.NewReader(stream)
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil && err != io.EOF {
+		defer j.logStreamWG.Done()
+		var (
+			curStream    io.Reader = stream
+			restartCount           = j.containerRestartCount(pod, container.Name)
+			streamed     bool
+		)
+		for reconnectCount := 0; ; reconnectCount++ {
+			read, err := j.readLogStream(ctx, curStream, pod, container, enabledLog)
+			if err != nil {
 				errchan <- err
+				return
 			}
-			if err == nil {
-				if enabledLog {
-					j.containerLogs <- &ContainerLog{
-						Pod:       pod,
-						Container: container,
-						Log:       line,
-					}
-				}
+			if read {
+				streamed = true
 			}
-			if err == io.EOF {
-				j.containerLogs <- &ContainerLog{
-					Pod:        pod,
-					Container:  container,
-					Log:        "",
-					IsFinished: true,
-				}
-				errchan <- nil
+			if reconnectCount >= LogStreamReconnectCount {
+				break
+			}
+			// if the container is restarted, the log stream of the previous container reaches EOF.
+			// In this case, reopen the log stream for the new container to capture logs continuously.
+			restarted, err := j.waitForContainerRestart(ctx, pod.Name, container.Name, restartCount)
+			if err != nil {
+				errchan <- err
+				return
+			}
+			if !restarted {
+				break
+			}
+			j.logDebug("reconnect log stream of %s because the container was restarted", container.Name)
+			restartCount++
+			newStream, err := j.openLogStream(ctx, pod, container)
+			if err != nil {
+				errchan <- err
+				return
 			}
+			defer newStream.Close()
+			curStream = newStream
 		}
+		if !streamed {
+			if err := j.readLogsAfterCompletion(ctx, pod, container, enabledLog); err != nil {
+				errchan <- err
+				return
+			}
+		}
+		j.finishLogPattern(container.Name)
+		j.sendContainerLog(ctx, &ContainerLog{
+			Pod:        pod,
+			Container:  container,
+			Log:        "",
+			IsFinished: true,
+		})
+		errchan <- nil
 	}()
 
 	select {
@@ -1,19 +1,48 @@
 package kubejob_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
+	goruntime "runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/goccy/kubejob"
+	"github.com/goccy/kubejob/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
 	batchv1 "k8s.io/api/batch/v1"
 	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	spdystream "k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 const (
@@ -29,6 +58,28 @@ func init() {
 	cfg = c
 }
 
+// newFakeClientset creates the fake clientset with objects.
+// Every watch request of the pods receives events as the modified pods in order.
+func newFakeClientset(events []*apiv1.Pod, objects ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		// the events may remain after the watcher is stopped ( e.g. cancelled ), so stop sending them by the watcher.
+		ch := make(chan watch.Event)
+		watcher := watch.NewProxyWatcher(ch)
+		go func() {
+			for _, pod := range events {
+				select {
+				case <-watcher.StopChan():
+					return
+				case ch <- watch.Event{Type: watch.Modified, Object: pod.DeepCopy()}:
+				}
+			}
+		}()
+		return true, watcher, nil
+	})
+	return clientset
+}
+
 func Test_SimpleRunning(t *testing.T) {
 	job, err := kubejob.NewJobBuilder(cfg, "default").
 		SetImage(goImageName).
@@ -725,6 +776,58 @@ func Test_RunnerWithSideCar(t *testing.T) {
 			t.Fatal("expect error")
 		}
 	})
+	t.Run("sidecar failure", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "kubejob-",
+			},
+			Spec: batchv1.JobSpec{
+				Template: apiv1.PodTemplateSpec{
+					Spec: apiv1.PodSpec{
+						Containers: []apiv1.Container{
+							{
+								Name:    "main",
+								Image:   goImageName,
+								Command: []string{"sh", "-c", "sleep 3; echo hello"},
+							},
+							{
+								Name:    "sidecar",
+								Image:   goImageName,
+								Command: []string{"sh", "-c", "exit 1"},
+							},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		job.SetTreatSidecarFailureAsJobFailure(true)
+		err = job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+			for _, exec := range executors {
+				if exec.Container.Name == "sidecar" {
+					exec.ExecAsync()
+				}
+			}
+			for _, exec := range executors {
+				if exec.Container.Name == "main" {
+					out, err := exec.Exec()
+					if err != nil {
+						t.Fatalf("%s: %+v", string(out), err)
+					}
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			t.Fatal("expect error")
+		}
+		var failedJob *kubejob.FailedJob
+		if !errors.As(err, &failedJob) {
+			t.Fatalf("cannot get FailedJob: %T", err)
+		}
+	})
 }
 
 func Test_RunnerWithCancel(t *testing.T) {
@@ -1103,3 +1206,5812 @@ echo -n "hello" > /tmp/artifact.txt
 		}
 	})
 }
+
+func Test_CreatedHandler(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	var createdJob *batchv1.Job
+	job.SetCreatedHandler(func(job *batchv1.Job) {
+		createdJob = job
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if createdJob == nil {
+		t.Fatal("failed to call created handler")
+	}
+	if createdJob.UID == "" {
+		t.Fatal("failed to get uid of created job")
+	}
+	if job.CreatedJob() != createdJob {
+		t.Fatal("failed to get created job")
+	}
+}
+
+func Test_SecurityContext(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"go", "version"}).
+		RunAsNonRoot(1000, 2000).
+		ReadOnlyRootFilesystem().
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	podSecurityContext := job.Spec.Template.Spec.SecurityContext
+	if podSecurityContext == nil {
+		t.Fatal("failed to set pod security context")
+	}
+	if podSecurityContext.RunAsNonRoot == nil || !*podSecurityContext.RunAsNonRoot {
+		t.Fatal("failed to set runAsNonRoot")
+	}
+	if podSecurityContext.RunAsUser == nil || *podSecurityContext.RunAsUser != 1000 {
+		t.Fatal("failed to set runAsUser")
+	}
+	if podSecurityContext.RunAsGroup == nil || *podSecurityContext.RunAsGroup != 2000 {
+		t.Fatal("failed to set runAsGroup")
+	}
+	containerSecurityContext := job.Spec.Template.Spec.Containers[0].SecurityContext
+	if containerSecurityContext == nil {
+		t.Fatal("failed to set container security context")
+	}
+	if containerSecurityContext.ReadOnlyRootFilesystem == nil || !*containerSecurityContext.ReadOnlyRootFilesystem {
+		t.Fatal("failed to set readOnlyRootFilesystem")
+	}
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+}
+
+func Test_RunnerWithExecutionWrapper(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	var wrapped bool
+	job.SetExecutionWrapper(func(container apiv1.Container) ([]string, []string) {
+		wrapped = true
+		return []string{"bash", "-c"}, []string{
+			`while [[ ! -f /tmp/kubejob-status ]]; do sleep 1; done; exit $(cat /tmp/kubejob-status)`,
+		}
+	})
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		for _, exec := range executors {
+			out, err := exec.Exec()
+			if err != nil {
+				t.Fatalf("%s: %+v", string(out), err)
+			}
+			if string(out) != "hello\n" {
+				t.Fatalf("cannot get output %q", string(out))
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if !wrapped {
+		t.Fatal("failed to call execution wrapper")
+	}
+}
+
+func Test_CopyFilesToPod(t *testing.T) {
+	dir, err := os.MkdirTemp("", "kubejob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+		files[file] = filepath.Join("/", "tmp", "dst", name)
+	}
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"cat", "/tmp/dst/a.txt", "/tmp/dst/b.txt", "/tmp/dst/c.txt"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		if len(executors) != 1 {
+			return fmt.Errorf("invalid executor num. expected 1 but got %d", len(executors))
+		}
+		if err := executors[0].CopyFilesToPod(files); err != nil {
+			return fmt.Errorf("failed to copy: %w", err)
+		}
+		out, err := executors[0].Exec()
+		if err != nil {
+			return fmt.Errorf("failed to execute command: %w", err)
+		}
+		if string(out) != "a.txtb.txtc.txt" {
+			t.Fatalf("invalid content: %s", string(out))
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func Test_CopyFilesToPodWithRelativePath(t *testing.T) {
+	server, executedCommands := newExecServer(func(string, []string) bool { return false })
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(file, []byte("a"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	pod := &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	exec := job.NewExecutor(pod, apiv1.Container{Name: "test"})
+	err = exec.CopyFilesToPod(map[string]string{file: "tmp/dst/a.txt"})
+	if err == nil {
+		t.Fatal("expected error for relative destination path")
+	}
+	var copyErr *kubejob.CopyError
+	if !errors.As(err, &copyErr) {
+		t.Fatalf("unexpected error type %T: %v", err, err)
+	}
+	if copyErr.DstPath != "tmp/dst/a.txt" {
+		t.Fatalf("unexpected destination path %q", copyErr.DstPath)
+	}
+	if commands := executedCommands(); len(commands) != 0 {
+		t.Fatalf("expected not to copy files: %v", commands)
+	}
+}
+
+func Test_ExecAsync(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubejob-",
+		},
+		Spec: batchv1.JobSpec{
+			Template: apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					Containers: []apiv1.Container{
+						{
+							Name:    "main",
+							Image:   goImageName,
+							Command: []string{"echo", "hello"},
+						},
+						{
+							Name:    "async",
+							Image:   goImageName,
+							Command: []string{"sh", "-c"},
+							Args:    []string{"sleep 3; echo async"},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		var (
+			async *kubejob.AsyncExec
+			main  *kubejob.JobExecutor
+		)
+		for _, exec := range executors {
+			if exec.Container.Name == "async" {
+				async = exec.ExecAsync()
+			} else {
+				main = exec
+			}
+		}
+		out, err := main.Exec()
+		if err != nil {
+			t.Fatalf("%s: %+v", string(out), err)
+		}
+		asyncOut, err := async.Wait()
+		if err != nil {
+			t.Fatalf("%s: %+v", string(asyncOut), err)
+		}
+		if string(asyncOut) != "async\n" {
+			t.Fatalf("cannot get output %q", string(asyncOut))
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+}
+
+func Test_KillExecAsyncWhileFinishing(t *testing.T) {
+	server, executedCommands := newExecServer(func(string, []string) bool { return false })
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.DisableCommandLog()
+	const num = 10
+	for i := 0; i < num; i++ {
+		pod := &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-%d", i), Namespace: "default"}}
+		exec := job.NewExecutor(pod, apiv1.Container{Name: "async"})
+		async := exec.ExecAsync()
+		// Kill races with the stop of the finished command.
+		if err := async.Kill(); err != nil {
+			t.Fatalf("failed to kill: %+v", err)
+		}
+		if _, err := async.Wait(); err != nil {
+			t.Fatalf("failed to wait: %+v", err)
+		}
+		var stopCount int
+		for _, cmd := range executedCommands()[pod.Name] {
+			if strings.Contains(cmd, "echo 0") || strings.Contains(cmd, "echo 1") {
+				stopCount++
+			}
+		}
+		if stopCount != 1 {
+			t.Fatalf("expected to stop the container only once: %v", executedCommands()[pod.Name])
+		}
+	}
+}
+
+func Test_RunWithCancelDuringLogging(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"sh", "-c", "while true; do echo kubejob; done"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var logNum int32
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		if atomic.AddInt32(&logNum, 1) == 1000 {
+			cancel()
+		}
+	})
+	baseGoroutineNum := goruntime.NumGoroutine()
+	if err := job.Run(ctx); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if atomic.LoadInt32(&logNum) < 1000 {
+		t.Fatalf("failed to capture logs: %d", logNum)
+	}
+	// allow goroutines of the idle http connections.
+	const allowedGoroutineNum = 5
+	for i := 0; i < 10; i++ {
+		if goruntime.NumGoroutine() <= baseGoroutineNum+allowedGoroutineNum {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	t.Fatalf("found leaked goroutines: base %d but got %d", baseGoroutineNum, goruntime.NumGoroutine())
+}
+
+func Test_InvalidLabel(t *testing.T) {
+	_, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubejob-",
+		},
+		Spec: batchv1.JobSpec{
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app": "invalid value!",
+					},
+				},
+				Spec: apiv1.PodSpec{
+					Containers: []apiv1.Container{
+						{
+							Name:    "test",
+							Image:   goImageName,
+							Command: []string{"echo", "hello"},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err == nil {
+		t.Fatal("expect error")
+	}
+	var labelErr *kubejob.InvalidLabelError
+	if !errors.As(err, &labelErr) {
+		t.Fatalf("cannot get InvalidLabelError: %+v", err)
+	}
+	if labelErr.Key != "app" {
+		t.Fatalf("unexpected label key %s", labelErr.Key)
+	}
+}
+
+func Test_SuspendAndResume(t *testing.T) {
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		SetSuspended(true).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	created := make(chan struct{})
+	job.SetCreatedHandler(func(*batchv1.Job) {
+		close(created)
+	})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- job.Run(context.Background())
+	}()
+	<-created
+	time.Sleep(3 * time.Second)
+	pods, err := clientset.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", kubejob.SelectorLabel, job.Spec.Template.Labels[kubejob.SelectorLabel]),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 0 {
+		t.Fatalf("expected no pods while suspended but found %d pods", len(pods.Items))
+	}
+	if err := job.Resume(context.Background()); err != nil {
+		t.Fatalf("failed to resume: %+v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+}
+
+func Test_QPSAndBurst(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		SetQPS(100).
+		SetBurst(200).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	config := job.RESTConfig()
+	if config.QPS != 100 {
+		t.Fatalf("failed to set qps: %f", config.QPS)
+	}
+	if config.Burst != 200 {
+		t.Fatalf("failed to set burst: %d", config.Burst)
+	}
+	if config == cfg {
+		t.Fatal("expected copied config")
+	}
+}
+
+func Test_RunWithAllExecutionHandlers(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubejob-",
+		},
+		Spec: batchv1.JobSpec{
+			Template: apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					InitContainers: []apiv1.Container{
+						{
+							Name:    "init",
+							Image:   goImageName,
+							Command: []string{"echo", "init"},
+						},
+					},
+					Containers: []apiv1.Container{
+						{
+							Name:    "main",
+							Image:   goImageName,
+							Command: []string{"echo", "main"},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	outputs := []string{}
+	if err := job.RunWithAllExecutionHandlers(context.Background(), func(exec *kubejob.JobExecutor) error {
+		out, err := exec.Exec()
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, fmt.Sprintf("%s:%s", exec.Container.Name, string(out)))
+		return nil
+	}, func(executors []*kubejob.JobExecutor) error {
+		for _, exec := range executors {
+			out, err := exec.Exec()
+			if err != nil {
+				return err
+			}
+			outputs = append(outputs, fmt.Sprintf("%s:%s", exec.Container.Name, string(out)))
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if strings.Join(outputs, "") != "init:init\nmain:main\n" {
+		t.Fatalf("unexpected outputs: %q", outputs)
+	}
+}
+
+func Test_ExecWithOutput(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"sh", "-c", "echo stdout; echo stderr >&2; exit 1"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		for _, exec := range executors {
+			out, err := exec.ExecWithOutput()
+			if err == nil {
+				t.Fatal("expect error")
+			}
+			var failedJob *kubejob.FailedJob
+			if !errors.As(err, &failedJob) {
+				t.Fatalf("cannot get FailedJob: %+v", err)
+			}
+			if string(out.Stdout) != "stdout\n" {
+				t.Fatalf("cannot get stdout %q", string(out.Stdout))
+			}
+			if string(out.Stderr) != "stderr\n" {
+				t.Fatalf("cannot get stderr %q", string(out.Stderr))
+			}
+		}
+		return nil
+	}); err == nil {
+		t.Fatal("expect error")
+	}
+}
+
+func Test_ExecWithOutputReadFailure(t *testing.T) {
+	defer kubejob.SetExecRetryCount(1)()
+	server, _ := newExecServer(func(_ string, cmd []string) bool {
+		return strings.Contains(strings.Join(cmd, " "), "/tmp/kubejob-stdout")
+	})
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.DisableCommandLog()
+	pod := &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	exec := job.NewExecutor(pod, apiv1.Container{Name: "test"})
+	_, err = exec.ExecWithOutput()
+	if err == nil {
+		t.Fatal("expect error")
+	}
+	var failedJob *kubejob.FailedJob
+	if !errors.As(err, &failedJob) {
+		t.Fatalf("expected the error of the command to be kept: %+v", err)
+	}
+	if !strings.Contains(err.Error(), "failed to read stdout of command") {
+		t.Fatalf("expected the read error to be included: %+v", err)
+	}
+}
+
+func Test_LogStreamWithRestartedContainer(t *testing.T) {
+	backoffLimit := int32(2)
+	job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubejob-",
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					RestartPolicy: apiv1.RestartPolicyOnFailure,
+					Containers: []apiv1.Container{
+						{
+							Name:    "test",
+							Image:   goImageName,
+							Command: []string{"sh", "-c"},
+							Args: []string{`
+if [ -f /tmp/mnt/restarted ]; then
+  echo second
+else
+  touch /tmp/mnt/restarted
+  echo first
+  sleep 3
+  exit 1
+fi
+`},
+							VolumeMounts: []apiv1.VolumeMount{
+								{
+									Name:      "shared",
+									MountPath: "/tmp/mnt",
+								},
+							},
+						},
+					},
+					Volumes: []apiv1.Volume{
+						{
+							Name: "shared",
+							VolumeSource: apiv1.VolumeSource{
+								EmptyDir: &apiv1.EmptyDirVolumeSource{},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	logs := []string{}
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		if cl.IsFinished {
+			return
+		}
+		logs = append(logs, cl.Log)
+	})
+	job.DisableCommandLog()
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if strings.Join(logs, "") != "first\nsecond\n" {
+		t.Fatalf("failed to capture logs of restarted container: %q", logs)
+	}
+}
+
+func Test_OverrideImage(t *testing.T) {
+	manifest := `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: kubejob-
+spec:
+  template:
+    spec:
+      containers:
+      - name: test
+        image: placeholder
+        command: ["go", "version"]
+`
+	job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithReader(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if err := job.OverrideImage("unknown", goImageName); err == nil {
+		t.Fatal("expect error")
+	}
+	if err := job.OverrideImage("test", goImageName); err != nil {
+		t.Fatal(err)
+	}
+	var image string
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		image = cl.Pod.Spec.Containers[0].Image
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if image != goImageName {
+		t.Fatalf("failed to override image: %s", image)
+	}
+}
+
+func Test_PendingTimeout(t *testing.T) {
+	t.Run("unschedulable", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "kubejob-",
+			},
+			Spec: batchv1.JobSpec{
+				Template: apiv1.PodTemplateSpec{
+					Spec: apiv1.PodSpec{
+						NodeSelector: map[string]string{
+							"kubejob.io/unknown-node": "true",
+						},
+						Containers: []apiv1.Container{
+							{
+								Name:    "test",
+								Image:   goImageName,
+								Command: []string{"echo", "hello"},
+							},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		job.SetPendingTimeout(5 * time.Second)
+		err = job.Run(context.Background())
+		if err == nil {
+			t.Fatal("expect error")
+		}
+		var timeoutErr *kubejob.PendingTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("cannot get PendingTimeoutError: %+v", err)
+		}
+	})
+	t.Run("schedulable", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(cfg, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		job.SetPendingTimeout(1 * time.Minute)
+		if err := job.Run(context.Background()); err != nil {
+			t.Fatalf("failed to run: %+v", err)
+		}
+	})
+}
+
+func Test_LogChannel(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"sh", "-c", "echo hello; echo world"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.DisableCommandLog()
+	logCh := job.LogChannel()
+	done := make(chan struct{})
+	var (
+		logs     []string
+		finished int
+	)
+	go func() {
+		defer close(done)
+		for cl := range logCh {
+			if cl.IsFinished {
+				finished++
+				continue
+			}
+			logs = append(logs, cl.Log)
+		}
+	}()
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	<-done
+	if strings.Join(logs, "") != "hello\nworld\n" {
+		t.Fatalf("failed to receive logs: %q", logs)
+	}
+	if finished != 1 {
+		t.Fatalf("failed to receive finished marker: %d", finished)
+	}
+}
+
+func Test_CreateRetry(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := fake.NewSimpleClientset()
+	var createCount int
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		createCount++
+		if createCount == 1 {
+			return true, nil, apierrors.NewServerTimeout(schema.GroupResource{Group: "batch", Resource: "jobs"}, "create", 1)
+		}
+		return false, nil, nil
+	})
+	job.SetClientset(clientset, "default")
+	job.SetCreateRetry(3, 10*time.Millisecond)
+	if _, err := job.CreateJob(context.Background()); err != nil {
+		t.Fatalf("failed to create job: %+v", err)
+	}
+	if createCount != 2 {
+		t.Fatalf("unexpected create count: %d", createCount)
+	}
+}
+
+func Test_CreateRetryNotRetried(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryCount int
+		err        error
+	}{
+		{
+			name:       "default retry count",
+			retryCount: 0,
+			err:        apierrors.NewServerTimeout(schema.GroupResource{Group: "batch", Resource: "jobs"}, "create", 1),
+		},
+		{
+			name:       "timeout",
+			retryCount: 3,
+			err:        apierrors.NewTimeoutError("request timeout", 1),
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+				SetImage(goImageName).
+				SetCommand([]string{"echo", "hello"}).
+				Build()
+			if err != nil {
+				t.Fatalf("failed to build job: %+v", err)
+			}
+			clientset := fake.NewSimpleClientset()
+			var createCount int
+			clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				createCount++
+				return true, nil, test.err
+			})
+			job.SetClientset(clientset, "default")
+			if test.retryCount > 0 {
+				job.SetCreateRetry(test.retryCount, 10*time.Millisecond)
+			}
+			if _, err := job.CreateJob(context.Background()); err == nil {
+				t.Fatal("expected error")
+			}
+			if createCount != 1 {
+				t.Fatalf("unexpected create count: %d", createCount)
+			}
+		})
+	}
+}
+
+func Test_DNSConfigAndHostAliases(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"cat", "/etc/hosts"}).
+		SetDNSConfig(&apiv1.PodDNSConfig{
+			Nameservers: []string{"8.8.8.8"},
+		}).
+		AddHostAlias("10.0.0.1", "foo.local", "bar.local").
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	var pod *apiv1.Pod
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		pod = cl.Pod
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if pod == nil {
+		t.Fatal("failed to get pod")
+	}
+	dnsConfig := pod.Spec.DNSConfig
+	if dnsConfig == nil || len(dnsConfig.Nameservers) != 1 || dnsConfig.Nameservers[0] != "8.8.8.8" {
+		t.Fatalf("failed to set dns config: %+v", dnsConfig)
+	}
+	hostAliases := pod.Spec.HostAliases
+	if len(hostAliases) != 1 || hostAliases[0].IP != "10.0.0.1" || len(hostAliases[0].Hostnames) != 2 {
+		t.Fatalf("failed to set host aliases: %+v", hostAliases)
+	}
+}
+
+func Test_CancelExecutor(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubejob-",
+		},
+		Spec: batchv1.JobSpec{
+			Template: apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					Containers: []apiv1.Container{
+						{
+							Name:    "canceled",
+							Image:   goImageName,
+							Command: []string{"sleep", "30"},
+						},
+						{
+							Name:    "completed",
+							Image:   goImageName,
+							Command: []string{"sh", "-c", "sleep 3; echo completed"},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	err = job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		var eg errgroup.Group
+		for _, exec := range executors {
+			exec := exec
+			if exec.Container.Name == "canceled" {
+				eg.Go(func() error {
+					go func() {
+						time.Sleep(1 * time.Second)
+						if err := exec.Cancel(); err != nil {
+							t.Errorf("failed to cancel: %+v", err)
+						}
+					}()
+					if _, err := exec.Exec(); err == nil {
+						return fmt.Errorf("expected error by cancel")
+					}
+					return nil
+				})
+			} else {
+				eg.Go(func() error {
+					out, err := exec.Exec()
+					if err != nil {
+						return fmt.Errorf("%s: %w", string(out), err)
+					}
+					if string(out) != "completed\n" {
+						return fmt.Errorf("cannot get output %q", string(out))
+					}
+					return nil
+				})
+			}
+		}
+		if err := eg.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	})
+	// canceled command is treated as a failure of the container.
+	var failedJob *kubejob.FailedJob
+	if !errors.As(err, &failedJob) {
+		t.Fatalf("cannot get FailedJob: %+v", err)
+	}
+}
+
+func Test_LogBufferSize(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"sh", "-c", "printf '%0100d\\n' 0; echo -n last"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.DisableCommandLog()
+	job.SetLogBufferSize(32)
+	logs := []string{}
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		if cl.IsFinished {
+			return
+		}
+		logs = append(logs, cl.Log)
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if len(logs) < 2 {
+		t.Fatalf("failed to split long line: %q", logs)
+	}
+	for _, log := range logs {
+		if len(log) > 32 {
+			t.Fatalf("unexpected log length: %d", len(log))
+		}
+	}
+	if strings.Join(logs, "") != fmt.Sprintf("%0100d\nlast", 0) {
+		t.Fatalf("failed to capture logs: %q", logs)
+	}
+}
+
+func Test_LogWithoutTrailingNewline(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"printf", "no-newline"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.DisableCommandLog()
+	var (
+		logs     []string
+		finished bool
+	)
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		if cl.IsFinished {
+			finished = true
+			return
+		}
+		if finished {
+			t.Errorf("received log after finished marker: %q", cl.Log)
+		}
+		logs = append(logs, cl.Log)
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if strings.Join(logs, "") != "no-newline" {
+		t.Fatalf("failed to capture the last line: %q", logs)
+	}
+}
+
+func Test_CallbackReadiness(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.SetCallbackReadinessTimeout(10 * time.Second)
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		for _, exec := range executors {
+			for _, status := range exec.Pod.Status.ContainerStatuses {
+				if status.State.Running == nil {
+					t.Fatalf("container %s is not running when the handler is called", status.Name)
+				}
+			}
+			if _, err := exec.Exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+}
+
+func Test_BuildFromFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "kubejob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("yaml", func(t *testing.T) {
+		path := filepath.Join(dir, "job.yaml")
+		if err := os.WriteFile(path, []byte(`
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: kubejob-
+spec:
+  template:
+    spec:
+      containers:
+      - name: test
+        image: `+goImageName+`
+        command: ["echo", "hello"]
+`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		job, err := kubejob.NewJobBuilder(cfg, "default").BuildFromFile(path)
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if job.Spec.Template.Spec.Containers[0].Name != "test" {
+			t.Fatal("failed to load job from yaml")
+		}
+	})
+	t.Run("json", func(t *testing.T) {
+		path := filepath.Join(dir, "job.json")
+		if err := os.WriteFile(path, []byte(`{
+  "apiVersion": "batch/v1",
+  "kind": "Job",
+  "metadata": {"generateName": "kubejob-"},
+  "spec": {"template": {"spec": {"containers": [
+    {"name": "test", "image": "`+goImageName+`", "command": ["echo", "hello"]}
+  ]}}}
+}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		job, err := kubejob.NewJobBuilder(cfg, "default").BuildFromFile(path)
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if job.Spec.Template.Spec.Containers[0].Name != "test" {
+			t.Fatal("failed to load job from json")
+		}
+	})
+	t.Run("without extension", func(t *testing.T) {
+		path := filepath.Join(dir, "job")
+		if err := os.WriteFile(path, []byte(`
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: kubejob-
+spec:
+  template:
+    spec:
+      containers:
+      - name: test
+        image: `+goImageName+`
+        command: ["echo", "hello"]
+`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		job, err := kubejob.NewJobBuilder(cfg, "default").BuildFromFile(path)
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if job.Spec.Template.Spec.Containers[0].Name != "test" {
+			t.Fatal("failed to load job from the file without extension")
+		}
+	})
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := kubejob.NewJobBuilder(cfg, "default").BuildFromFile(filepath.Join(dir, "missing.yaml")); err == nil {
+			t.Fatal("expect error")
+		}
+	})
+}
+
+func Test_BuildFromManifest(t *testing.T) {
+	configMapName := fmt.Sprintf("kubejob-%d", time.Now().UnixNano())
+	manifest := fmt.Sprintf(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: %s
+data:
+  message: hello
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: kubejob-
+spec:
+  template:
+    spec:
+      containers:
+      - name: test
+        image: %s
+        command: ["sh", "-c", "echo $MESSAGE"]
+        env:
+        - name: MESSAGE
+          valueFrom:
+            configMapKeyRef:
+              name: %s
+              key: message
+`, configMapName, goImageName, configMapName)
+	job, err := kubejob.NewJobBuilder(cfg, "default").BuildFromManifest(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.DisableCommandLog()
+	logs := []string{}
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		logs = append(logs, cl.Log)
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if strings.Join(logs, "") != "hello\n" {
+		t.Fatalf("failed to read configmap: %q", logs)
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().ConfigMaps("default").Get(context.Background(), configMapName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected configmap to be deleted: %v", err)
+	}
+}
+
+func Test_BuildFromManifestWithGenerateName(t *testing.T) {
+	manifest := fmt.Sprintf(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  generateName: kubejob-
+data:
+  message: hello
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  generateName: kubejob-
+stringData:
+  message: hello
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: kubejob-
+spec:
+  template:
+    spec:
+      containers:
+      - name: test
+        image: %s
+        command: ["echo", "hello"]
+`, goImageName)
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").BuildFromManifest(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}})
+	var (
+		created []string
+		deleted []string
+	)
+	for _, resource := range []string{"configmaps", "secrets"} {
+		resource := resource
+		clientset.PrependReactor("create", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			obj := action.(k8stesting.CreateAction).GetObject().(metav1.Object)
+			if obj.GetName() == "" {
+				obj.SetName(obj.GetGenerateName() + "generated")
+			}
+			created = append(created, resource+"/"+obj.GetName())
+			return false, nil, nil
+		})
+		clientset.PrependReactor("delete", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			deleted = append(deleted, resource+"/"+action.(k8stesting.DeleteAction).GetName())
+			return false, nil, nil
+		})
+	}
+	job.SetClientset(clientset, "default")
+	job.DisableCommandLog()
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	const expected = "configmaps/kubejob-generated,secrets/kubejob-generated"
+	if strings.Join(created, ",") != expected {
+		t.Fatalf("unexpected created resources: %v", created)
+	}
+	if strings.Join(deleted, ",") != expected {
+		t.Fatalf("expected generated resources to be deleted: %v", deleted)
+	}
+}
+
+func Test_BuildFromManifestWithOtherNamespace(t *testing.T) {
+	jobManifest := fmt.Sprintf(`
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: kubejob-
+spec:
+  template:
+    spec:
+      containers:
+      - name: test
+        image: %s
+        command: ["echo", "hello"]
+`, goImageName)
+	tests := []struct {
+		name     string
+		resource string
+		valid    bool
+	}{
+		{
+			name: "configmap in other namespace",
+			resource: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test
+  namespace: other
+data:
+  message: hello
+`,
+		},
+		{
+			name: "secret in other namespace",
+			resource: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: test
+  namespace: other
+stringData:
+  message: hello
+`,
+		},
+		{
+			name: "configmap in job namespace",
+			resource: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test
+  namespace: default
+data:
+  message: hello
+`,
+			valid: true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			manifest := test.resource + "---" + jobManifest
+			_, err := kubejob.NewJobBuilder(&rest.Config{}, "default").BuildFromManifest(strings.NewReader(manifest))
+			if test.valid {
+				if err != nil {
+					t.Fatalf("failed to build job: %+v", err)
+				}
+				return
+			}
+			var validationErr *kubejob.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_WatchResumption(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := fake.NewSimpleClientset()
+	var resourceVersions []string
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watchAction := action.(k8stesting.WatchActionImpl)
+		resourceVersions = append(resourceVersions, watchAction.WatchRestrictions.ResourceVersion)
+		watcher := watch.NewFake()
+		if len(resourceVersions) == 1 {
+			go func() {
+				watcher.Add(&apiv1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "test", ResourceVersion: "100"},
+					Status:     apiv1.PodStatus{Phase: apiv1.PodPending},
+				})
+				// simulate the timeout of the watch request.
+				watcher.Stop()
+			}()
+		} else {
+			go func() {
+				watcher.Modify(&apiv1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "test", ResourceVersion: "101"},
+					Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+				})
+			}()
+		}
+		return true, watcher, nil
+	})
+	clientset.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.Pod{Status: apiv1.PodStatus{Phase: apiv1.PodSucceeded}}, nil
+	})
+	job.SetClientset(clientset, "default")
+	job.SetWatchTimeout(10 * time.Second)
+	if err := job.Wait(context.Background()); err != nil {
+		t.Fatalf("failed to wait: %+v", err)
+	}
+	if len(resourceVersions) != 2 {
+		t.Fatalf("expected to resume watch: %v", resourceVersions)
+	}
+	if resourceVersions[1] != "100" {
+		t.Fatalf("expected to resume from the last resourceVersion but got %q", resourceVersions[1])
+	}
+}
+
+func Test_ContainerStatus(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if _, found := job.ContainerStatus("test"); found {
+		t.Fatal("expected not to find the container status before run")
+	}
+	startedAt := metav1.NewTime(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	finishedAt := metav1.NewTime(startedAt.Add(time.Minute))
+	clientset := newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status: apiv1.PodStatus{
+			Phase: apiv1.PodSucceeded,
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{
+					Name: "test",
+					State: apiv1.ContainerState{
+						Terminated: &apiv1.ContainerStateTerminated{
+							Reason:     "Completed",
+							StartedAt:  startedAt,
+							FinishedAt: finishedAt,
+						},
+					},
+				},
+			},
+		},
+	}})
+	job.SetClientset(clientset, "default")
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+	if err := job.Wait(context.Background()); err != nil {
+		t.Fatalf("failed to wait: %+v", err)
+	}
+	status, found := job.ContainerStatus("test")
+	if !found {
+		t.Fatal("failed to find the container status")
+	}
+	terminated := status.State.Terminated
+	if terminated == nil {
+		t.Fatal("expected terminated state")
+	}
+	if terminated.Reason != "Completed" {
+		t.Fatalf("unexpected reason: %s", terminated.Reason)
+	}
+	if !terminated.StartedAt.Equal(&startedAt) || !terminated.FinishedAt.Equal(&finishedAt) {
+		t.Fatalf("unexpected timestamps: %v %v", terminated.StartedAt, terminated.FinishedAt)
+	}
+	if _, found := job.ContainerStatus("unknown"); found {
+		t.Fatal("expected not to find the unknown container")
+	}
+}
+
+func Test_TopologySpreadConstraint(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		AddTopologySpreadConstraint(apiv1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       "topology.kubernetes.io/zone",
+			WhenUnsatisfiable: apiv1.ScheduleAnyway,
+		}).
+		AddTopologySpreadConstraint(apiv1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       "kubernetes.io/hostname",
+			WhenUnsatisfiable: apiv1.DoNotSchedule,
+		}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.SetClientset(fake.NewSimpleClientset(), "default")
+	created, err := job.CreateJob(context.Background())
+	if err != nil {
+		t.Fatalf("failed to create job: %+v", err)
+	}
+	constraints := created.Spec.Template.Spec.TopologySpreadConstraints
+	if len(constraints) != 2 {
+		t.Fatalf("unexpected constraints: %+v", constraints)
+	}
+	if constraints[0].TopologyKey != "topology.kubernetes.io/zone" || constraints[1].TopologyKey != "kubernetes.io/hostname" {
+		t.Fatalf("unexpected constraints: %+v", constraints)
+	}
+}
+
+// contextRecorder records the context passed to the Pod and Job clients because the reactors of the fake client don't receive it.
+type contextRecorder struct {
+	*fake.Clientset
+	mu   sync.Mutex
+	ctxs map[string]context.Context
+}
+
+func (r *contextRecorder) record(verb string, ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ctxs == nil {
+		r.ctxs = map[string]context.Context{}
+	}
+	r.ctxs[verb] = ctx
+}
+
+func (r *contextRecorder) context(verb string) context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ctxs[verb]
+}
+
+func (r *contextRecorder) CoreV1() typedcorev1.CoreV1Interface {
+	return &contextRecorderCoreV1{CoreV1Interface: r.Clientset.CoreV1(), recorder: r}
+}
+
+func (r *contextRecorder) BatchV1() typedbatchv1.BatchV1Interface {
+	return &contextRecorderBatchV1{BatchV1Interface: r.Clientset.BatchV1(), recorder: r}
+}
+
+type contextRecorderCoreV1 struct {
+	typedcorev1.CoreV1Interface
+	recorder *contextRecorder
+}
+
+func (c *contextRecorderCoreV1) Pods(namespace string) typedcorev1.PodInterface {
+	return &contextRecorderPods{PodInterface: c.CoreV1Interface.Pods(namespace), recorder: c.recorder}
+}
+
+type contextRecorderPods struct {
+	typedcorev1.PodInterface
+	recorder *contextRecorder
+}
+
+func (p *contextRecorderPods) List(ctx context.Context, opts metav1.ListOptions) (*apiv1.PodList, error) {
+	p.recorder.record("list pods", ctx)
+	return p.PodInterface.List(ctx, opts)
+}
+
+func (p *contextRecorderPods) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	p.recorder.record("watch pods", ctx)
+	return p.PodInterface.Watch(ctx, opts)
+}
+
+type contextRecorderBatchV1 struct {
+	typedbatchv1.BatchV1Interface
+	recorder *contextRecorder
+}
+
+func (b *contextRecorderBatchV1) Jobs(namespace string) typedbatchv1.JobInterface {
+	return &contextRecorderJobs{JobInterface: b.BatchV1Interface.Jobs(namespace), recorder: b.recorder}
+}
+
+type contextRecorderJobs struct {
+	typedbatchv1.JobInterface
+	recorder *contextRecorder
+}
+
+func (j *contextRecorderJobs) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	j.recorder.record("delete jobs", ctx)
+	return j.JobInterface.Delete(ctx, name, opts)
+}
+
+func Test_RunWithCancelledContext(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	// the pod watcher never sends any events and never closes.
+	clientset := newFakeClientset(nil)
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		cancel()
+		return false, nil, nil
+	})
+	recorder := &contextRecorder{Clientset: clientset}
+	job.SetClientset(recorder, "default")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- job.Run(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not observe the cancelled context")
+	}
+	watchCtx := recorder.context("watch pods")
+	if watchCtx == nil {
+		t.Fatal("pods were not watched")
+	}
+	if !errors.Is(watchCtx.Err(), context.Canceled) {
+		t.Fatalf("expected the watch to observe the cancellation: %v", watchCtx.Err())
+	}
+	// the cleanup must not be aborted by the cancelled context.
+	for _, verb := range []string{"list pods", "delete jobs"} {
+		ctx := recorder.context(verb)
+		if ctx == nil {
+			t.Fatalf("expected to cleanup after cancellation: %s was not called", verb)
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("unexpected cancelled context for %s: %v", verb, ctx.Err())
+		}
+	}
+}
+
+func Test_CancelWhileRunningExecutionHandler(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"sleep", "3600"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{Name: kubejob.DefaultContainerName}},
+		},
+		Status: apiv1.PodStatus{
+			Phase: apiv1.PodRunning,
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{
+					Name:  kubejob.DefaultContainerName,
+					Ready: true,
+					State: apiv1.ContainerState{Running: &apiv1.ContainerStateRunning{}},
+				},
+			},
+		},
+	}})
+	job.SetClientset(clientset, "default")
+	job.SetLogContainers()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	called := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- job.RunWithExecutionHandler(ctx, func(executors []*kubejob.JobExecutor) error {
+			close(called)
+			// the command that doesn't take the context never returns.
+			select {}
+		})
+	}()
+	select {
+	case <-called:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the execution handler was not called")
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run waited for the execution handler after cancellation")
+	}
+}
+
+func Test_RunOutput(t *testing.T) {
+	out, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		RunOutput(context.Background())
+	if err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if out != "hello" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func Test_Finalizers(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.SetFinalizers([]string{"example.com/observer"})
+	clientset := fake.NewSimpleClientset()
+	job.SetClientset(clientset, "default")
+	created, err := job.CreateJob(context.Background())
+	if err != nil {
+		t.Fatalf("failed to create job: %+v", err)
+	}
+	finalizers := created.Finalizers
+	if len(finalizers) != 2 || finalizers[0] != "example.com/observer" || finalizers[1] != kubejob.JobFinalizer {
+		t.Fatalf("unexpected finalizers: %v", finalizers)
+	}
+}
+
+func Test_PreviousContainerLogs(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status: apiv1.PodStatus{
+			Phase: apiv1.PodFailed,
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{Name: "test", RestartCount: 1},
+			},
+		},
+	}})
+	job.SetClientset(clientset, "default")
+	if _, err := job.PreviousContainerLogs(context.Background(), "test"); err == nil {
+		t.Fatal("expected error before the pod is observed")
+	}
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+	var failedJob *kubejob.FailedJob
+	if err := job.Wait(context.Background()); !errors.As(err, &failedJob) {
+		t.Fatalf("expected failed job error but got %+v", err)
+	}
+	logs, err := job.PreviousContainerLogs(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("failed to get previous logs: %+v", err)
+	}
+	if len(logs) == 0 {
+		t.Fatal("failed to get previous logs")
+	}
+	var found bool
+	for _, action := range clientset.Actions() {
+		if action.GetSubresource() != "log" {
+			continue
+		}
+		opts, ok := action.(k8stesting.GenericActionImpl).Value.(*apiv1.PodLogOptions)
+		if ok && opts.Container == "test" && opts.Previous {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected to request the previous logs")
+	}
+}
+
+func Test_SetWorkingDir(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"ls"}).
+		SetWorkingDir("/tmp").
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if dir := job.Spec.Template.Spec.Containers[0].WorkingDir; dir != "/tmp" {
+		t.Fatalf("unexpected working dir: %q", dir)
+	}
+}
+
+func Test_ExecCommand(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "original"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		for _, exec := range executors {
+			out, err := exec.ExecCommand("echo", "adhoc")
+			if err != nil {
+				return err
+			}
+			if string(out) != "adhoc\n" {
+				t.Fatalf("unexpected output of ad-hoc command: %q", string(out))
+			}
+			out, err = exec.Exec()
+			if err != nil {
+				return err
+			}
+			if string(out) != "original\n" {
+				t.Fatalf("unexpected output of original command: %q", string(out))
+			}
+			if _, err := exec.ExecCommand("echo", "adhoc"); err == nil {
+				t.Fatal("expect error after container is stopped")
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func Test_NewJobBuilderInNamespace(t *testing.T) {
+	t.Run("detect namespace", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "namespace")
+		if err := os.WriteFile(path, []byte("kubejob-ns\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		defer kubejob.SetServiceAccountNamespacePath(path)()
+		if ns := kubejob.NewJobBuilderInNamespace(&rest.Config{}).Namespace(); ns != "kubejob-ns" {
+			t.Fatalf("unexpected namespace: %s", ns)
+		}
+	})
+	t.Run("fallback to default", func(t *testing.T) {
+		defer kubejob.SetServiceAccountNamespacePath(filepath.Join(t.TempDir(), "not-found"))()
+		if ns := kubejob.NewJobBuilderInNamespace(&rest.Config{}).Namespace(); ns != "default" {
+			t.Fatalf("unexpected namespace: %s", ns)
+		}
+	})
+}
+
+func Test_SetCompletionMode(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		SetCompletionMode(batchv1.IndexedCompletion).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.SetClientset(fake.NewSimpleClientset(), "default")
+	created, err := job.CreateJob(context.Background())
+	if err != nil {
+		t.Fatalf("failed to create job: %+v", err)
+	}
+	mode := created.Spec.CompletionMode
+	if mode == nil || *mode != batchv1.IndexedCompletion {
+		t.Fatalf("unexpected completion mode: %v", mode)
+	}
+	log := &kubejob.ContainerLog{
+		Pod: &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{batchv1.JobCompletionIndexAnnotationAlpha: "2"},
+			},
+		},
+	}
+	if index, exists := log.CompletionIndex(); !exists || index != "2" {
+		t.Fatalf("unexpected completion index: %q", index)
+	}
+}
+
+func Test_LogDrain(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"sh", "-c", "seq 1 1000"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.DisableCommandLog()
+	job.SetLogDrainTimeout(time.Minute)
+	var lastLine string
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		if cl.IsFinished {
+			return
+		}
+		lines := strings.Split(strings.TrimSpace(cl.Log), "\n")
+		lastLine = lines[len(lines)-1]
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if lastLine != "1000" {
+		t.Fatalf("failed to get the final line before Run returns: %q", lastLine)
+	}
+}
+
+func Test_ExecWithTTY(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		for _, exec := range executors {
+			exec.SetTerminalSize(80, 24)
+			out, err := exec.ExecWithTTY([]string{"sh", "-c", "test -t 1 && echo tty"})
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(string(out)) != "tty" {
+				t.Fatalf("expected to run with tty but got %q", string(out))
+			}
+			out, err = exec.ExecCommand("sh", "-c", "test -t 1 || echo notty")
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(string(out)) != "notty" {
+				t.Fatalf("expected to run without tty but got %q", string(out))
+			}
+			if _, err := exec.Exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func Test_SetPriorityClassName(t *testing.T) {
+	build := func(t *testing.T) *kubejob.Job {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			SetPriorityClassName("high-priority").
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		return job
+	}
+	t.Run("set to pod spec", func(t *testing.T) {
+		job := build(t)
+		job.SetClientset(fake.NewSimpleClientset(), "default")
+		created, err := job.CreateJob(context.Background())
+		if err != nil {
+			t.Fatalf("failed to create job: %+v", err)
+		}
+		if name := created.Spec.Template.Spec.PriorityClassName; name != "high-priority" {
+			t.Fatalf("unexpected priority class name: %q", name)
+		}
+	})
+	t.Run("not found", func(t *testing.T) {
+		job := build(t)
+		job.SetClientset(fake.NewSimpleClientset(), "default")
+		var notFoundErr *kubejob.PriorityClassNotFoundError
+		if err := job.Run(context.Background()); !errors.As(err, &notFoundErr) {
+			t.Fatalf("expected PriorityClassNotFoundError but got %+v", err)
+		}
+		if notFoundErr.Name != "high-priority" {
+			t.Fatalf("unexpected priority class name: %q", notFoundErr.Name)
+		}
+	})
+}
+
+func Test_ArchMismatchError(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status: apiv1.PodStatus{
+			Phase: apiv1.PodFailed,
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{
+					Name: "test",
+					State: apiv1.ContainerState{
+						Terminated: &apiv1.ContainerStateTerminated{
+							Reason:   "StartError",
+							ExitCode: 128,
+							Message:  "exec: \"echo\": exec format error: unknown",
+						},
+					},
+				},
+			},
+		},
+	}})
+	job.SetClientset(clientset, "default")
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+	err = job.Wait(context.Background())
+	var archErr *kubejob.ArchMismatchError
+	if !errors.As(err, &archErr) {
+		t.Fatalf("expected ArchMismatchError but got %+v", err)
+	}
+	if archErr.ContainerName != "test" {
+		t.Fatalf("unexpected container name: %s", archErr.ContainerName)
+	}
+	var failedJob *kubejob.FailedJob
+	if !errors.As(err, &failedJob) {
+		t.Fatalf("expected to be handled as FailedJob: %+v", err)
+	}
+}
+
+func Test_SidecarShutdown(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetAnnotations(map[string]string{"sidecar.istio.io/inject": "false"}).
+		SetSidecarShutdown(true).
+		BuildWithJob(&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "kubejob-",
+			},
+			Spec: batchv1.JobSpec{
+				Template: apiv1.PodTemplateSpec{
+					Spec: apiv1.PodSpec{
+						Containers: []apiv1.Container{
+							{
+								Name:    "main",
+								Image:   goImageName,
+								Command: []string{"echo", "hello"},
+							},
+							{
+								// mock sidecar terminates only after the shutdown call.
+								Name:    "istio-proxy",
+								Image:   goImageName,
+								Command: []string{"sh", "-c", "trap 'exit 0' TERM; while true; do sleep 1; done"},
+							},
+						},
+					},
+				},
+			},
+		})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if v := job.Spec.Template.Annotations["sidecar.istio.io/inject"]; v != "false" {
+		t.Fatalf("failed to set annotation: %q", v)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+	if err := job.Run(ctx); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("the pod did not complete because the sidecar was not shutdown")
+	}
+}
+
+func Test_SidecarShutdownOrder(t *testing.T) {
+	run := func(t *testing.T, sidecar string, failed ...string) []string {
+		server, executedCommands := newExecServer(func(pod string, cmd []string) bool {
+			for _, f := range failed {
+				if strings.Contains(strings.Join(cmd, " "), f) {
+					return true
+				}
+			}
+			return false
+		})
+		defer server.Close()
+
+		job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			SetSidecarShutdown(true).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		job.SetLogger(func(string) {})
+		pod := &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec: apiv1.PodSpec{
+				Containers: []apiv1.Container{{Name: kubejob.DefaultContainerName}, {Name: sidecar}},
+			},
+		}
+		if err := job.ShutdownSidecars(context.Background(), pod); err != nil {
+			t.Fatalf("failed to shutdown sidecars: %+v", err)
+		}
+		var executed []string
+		for _, cmd := range executedCommands()["test"] {
+			if strings.HasPrefix(cmd, "sh ") {
+				t.Fatalf("expected to execute the command without the shell: %q", cmd)
+			}
+			for _, name := range []string{"pilot-agent", "curl", "kill"} {
+				if strings.Contains(cmd, name) {
+					executed = append(executed, name)
+				}
+			}
+		}
+		return executed
+	}
+	t.Run("istio-proxy", func(t *testing.T) {
+		if executed := run(t, "istio-proxy"); strings.Join(executed, ",") != "pilot-agent" {
+			t.Fatalf("unexpected commands: %v", executed)
+		}
+	})
+	t.Run("fallback to curl", func(t *testing.T) {
+		if executed := run(t, "istio-proxy", "pilot-agent"); strings.Join(executed, ",") != "pilot-agent,curl" {
+			t.Fatalf("unexpected commands: %v", executed)
+		}
+	})
+	t.Run("fallback to kill", func(t *testing.T) {
+		if executed := run(t, "istio-proxy", "pilot-agent", "curl"); strings.Join(executed, ",") != "pilot-agent,curl,kill" {
+			t.Fatalf("unexpected commands: %v", executed)
+		}
+	})
+	t.Run("unknown sidecar", func(t *testing.T) {
+		if executed := run(t, "unknown"); strings.Join(executed, ",") != "kill" {
+			t.Fatalf("unexpected commands: %v", executed)
+		}
+	})
+}
+
+type metricsCollector struct {
+	mu       sync.Mutex
+	created  []string
+	outcomes []kubejob.JobOutcome
+}
+
+func (r *metricsCollector) JobCreated(namespace string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.created = append(r.created, namespace)
+}
+
+func (r *metricsCollector) JobFinished(namespace string, outcome kubejob.JobOutcome, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes = append(r.outcomes, outcome)
+}
+
+func Test_MetricsCollector(t *testing.T) {
+	run := func(t *testing.T, ctx context.Context, events []*apiv1.Pod) *metricsCollector {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		job.SetClientset(newFakeClientset(events), "default")
+		job.DisableContainerLog()
+		job.DisableCommandLog()
+		recorder := &metricsCollector{}
+		job.SetMetricsCollector(recorder)
+		_ = job.Run(ctx)
+		return recorder
+	}
+	newPod := func(phase apiv1.PodPhase) *apiv1.Pod {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Status:     apiv1.PodStatus{Phase: phase},
+		}
+	}
+	t.Run("succeeded", func(t *testing.T) {
+		recorder := run(t, context.Background(), []*apiv1.Pod{newPod(apiv1.PodSucceeded)})
+		if len(recorder.created) != 1 || recorder.created[0] != "default" {
+			t.Fatalf("unexpected created: %v", recorder.created)
+		}
+		if len(recorder.outcomes) != 1 || recorder.outcomes[0] != kubejob.JobOutcomeSucceeded {
+			t.Fatalf("unexpected outcomes: %v", recorder.outcomes)
+		}
+	})
+	t.Run("failed", func(t *testing.T) {
+		recorder := run(t, context.Background(), []*apiv1.Pod{newPod(apiv1.PodFailed)})
+		if len(recorder.outcomes) != 1 || recorder.outcomes[0] != kubejob.JobOutcomeFailed {
+			t.Fatalf("unexpected outcomes: %v", recorder.outcomes)
+		}
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		// Run returns nil by the cancellation, but it must not be recorded as succeeded.
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		recorder := run(t, ctx, []*apiv1.Pod{newPod(apiv1.PodPending)})
+		if len(recorder.outcomes) != 1 || recorder.outcomes[0] != kubejob.JobOutcomeCancelled {
+			t.Fatalf("unexpected outcomes: %v", recorder.outcomes)
+		}
+	})
+}
+
+func Test_PrometheusMetricsCollector(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.SetClientset(newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}}), "default")
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+	collector := metrics.NewMetricsCollector()
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf("failed to register collector: %+v", err)
+	}
+	job.SetMetricsCollector(collector)
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %+v", err)
+	}
+	var (
+		succeeded float64
+		samples   uint64
+	)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["namespace"] != "default" {
+				t.Fatalf("unexpected namespace label: %v", labels)
+			}
+			switch family.GetName() {
+			case "kubejob_job_finished_total":
+				if labels["outcome"] == string(kubejob.JobOutcomeSucceeded) {
+					succeeded += metric.GetCounter().GetValue()
+				}
+			case "kubejob_job_duration_seconds":
+				if labels["outcome"] == string(kubejob.JobOutcomeSucceeded) {
+					samples += metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("unexpected succeeded count: %v", succeeded)
+	}
+	if samples != 1 {
+		t.Fatalf("unexpected duration sample count: %v", samples)
+	}
+}
+
+func Test_AgentServiceAccountToken(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	agentConfig, err := kubejob.NewAgentConfig(map[string]string{
+		kubejob.DefaultContainerName: filepath.Join("/", "bin", "kubejob-agent"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentConfig.SetServiceAccountToken(true)
+	job.UseAgent(agentConfig)
+
+	clientset := newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}})
+	job.SetClientset(clientset, "default")
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+	var created *batchv1.Job
+	job.SetCreatedHandler(func(job *batchv1.Job) {
+		created = job
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	spec := created.Spec.Template.Spec
+	if len(spec.Volumes) != 1 || spec.Volumes[0].Projected == nil {
+		t.Fatalf("failed to add projected volume: %+v", spec.Volumes)
+	}
+	if spec.Volumes[0].Projected.Sources[0].ServiceAccountToken == nil {
+		t.Fatalf("failed to add service account token projection: %+v", spec.Volumes[0])
+	}
+	mounts := spec.Containers[0].VolumeMounts
+	if len(mounts) != 1 || mounts[0].Name != spec.Volumes[0].Name {
+		t.Fatalf("failed to mount service account token: %+v", mounts)
+	}
+}
+
+func Test_AddMutator(t *testing.T) {
+	t.Run("mutate in order", func(t *testing.T) {
+		var called []string
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			AddMutator(func(job *batchv1.Job) error {
+				called = append(called, "first")
+				job.Spec.Template.Labels["app"] = "first"
+				return nil
+			}).
+			AddMutator(func(job *batchv1.Job) error {
+				called = append(called, "second")
+				job.Spec.Template.Labels["app"] += "-second"
+				return nil
+			}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if strings.Join(called, ",") != "first,second" {
+			t.Fatalf("unexpected order: %v", called)
+		}
+		if v := job.Spec.Template.Labels["app"]; v != "first-second" {
+			t.Fatalf("unexpected label: %q", v)
+		}
+	})
+	t.Run("abort build", func(t *testing.T) {
+		if _, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			AddMutator(func(job *batchv1.Job) error {
+				return fmt.Errorf("mutation error")
+			}).
+			Build(); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func Test_CleanupOnlyOwnedPods(t *testing.T) {
+	newPod := func(name, ownerUID string) *apiv1.Pod {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels:    map[string]string{kubejob.SelectorLabel: "shared"},
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: "Job", Name: name, UID: types.UID(ownerUID)},
+				},
+			},
+		}
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}}, newPod("job-a", "uid-job-a"), newPod("job-b", "uid-job-b"))
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.UID = types.UID("uid-" + job.Name)
+		return false, nil, nil
+	})
+	run := func(t *testing.T, name string) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			AddMutator(func(job *batchv1.Job) error {
+				// share the label with the other job.
+				job.Name = name
+				job.GenerateName = ""
+				job.Spec.Template.Labels[kubejob.SelectorLabel] = "shared"
+				return nil
+			}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		job.SetClientset(clientset, "default")
+		job.DisableContainerLog()
+		job.DisableCommandLog()
+		if err := job.Run(context.Background()); err != nil {
+			t.Fatalf("failed to run: %+v", err)
+		}
+	}
+	existsPod := func(name string) bool {
+		_, err := clientset.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+		return err == nil
+	}
+	run(t, "job-a")
+	if existsPod("job-a") {
+		t.Fatal("expected to delete the pod of job-a")
+	}
+	if !existsPod("job-b") {
+		t.Fatal("expected not to delete the pod of job-b")
+	}
+	run(t, "job-b")
+	if existsPod("job-b") {
+		t.Fatal("expected to delete the pod of job-b")
+	}
+}
+
+func Test_RunWithStatusChannel(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	statusCh, errCh := job.RunWithStatusChannel(context.Background())
+	var phases []apiv1.PodPhase
+	for status := range statusCh {
+		if len(phases) == 0 || phases[len(phases)-1] != status.Phase {
+			phases = append(phases, status.Phase)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if len(phases) == 0 {
+		t.Fatal("failed to receive status")
+	}
+	if phases[len(phases)-1] != apiv1.PodSucceeded {
+		t.Fatalf("unexpected phase progression: %v", phases)
+	}
+	order := map[apiv1.PodPhase]int{apiv1.PodPending: 0, apiv1.PodRunning: 1, apiv1.PodSucceeded: 2}
+	for i := 1; i < len(phases); i++ {
+		if order[phases[i-1]] > order[phases[i]] {
+			t.Fatalf("unexpected phase progression: %v", phases)
+		}
+	}
+}
+
+func Test_RunWithStatusChannelCancel(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	events := make([]*apiv1.Pod, 1000)
+	for idx := range events {
+		events[idx] = &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Status:     apiv1.PodStatus{Phase: apiv1.PodPending},
+		}
+	}
+	job.SetClientset(newFakeClientset(events), "default")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	statusCh, errCh := job.RunWithStatusChannel(ctx)
+	var received int
+	for range statusCh {
+		received++
+		if received == 1 {
+			// cancel while the status events are still arriving.
+			cancel()
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	// wait for the watch loop to observe the remaining events after the status channel is closed.
+	time.Sleep(100 * time.Millisecond)
+}
+
+func Test_WaitForNode(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	nodeNameCh := make(chan string, 1)
+	go func() {
+		nodeName, err := job.WaitForNode(context.Background())
+		if err != nil {
+			nodeName = ""
+		}
+		nodeNameCh <- nodeName
+	}()
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		// the container is running at this point, so the node name must have been already notified.
+		select {
+		case nodeName := <-nodeNameCh:
+			if nodeName == "" {
+				t.Fatal("failed to get node name")
+			}
+		default:
+			t.Fatal("expected to get node name before the container starts")
+		}
+		for _, exec := range executors {
+			if _, err := exec.Exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func Test_JobDeletedError(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watcher := watch.NewFake()
+		go func() {
+			pod := &apiv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Status:     apiv1.PodStatus{Phase: apiv1.PodPending},
+			}
+			watcher.Add(pod)
+			watcher.Delete(pod)
+		}()
+		return true, watcher, nil
+	})
+	job.SetClientset(clientset, "default")
+	done := make(chan error, 1)
+	go func() {
+		done <- job.Wait(context.Background())
+	}()
+	select {
+	case err := <-done:
+		var deletedErr *kubejob.JobDeletedError
+		if !errors.As(err, &deletedErr) {
+			t.Fatalf("expected JobDeletedError but got %+v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("hang up after the pod was deleted")
+	}
+}
+
+func Test_WatchErrorEvent(t *testing.T) {
+	t.Run("return watch error", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+			watcher := watch.NewFake()
+			go func() {
+				watcher.Error(&metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    500,
+					Reason:  metav1.StatusReasonInternalError,
+					Message: "internal error",
+				})
+			}()
+			return true, watcher, nil
+		})
+		job.SetClientset(clientset, "default")
+		var watchErr *kubejob.JobWatchError
+		if err := job.Wait(context.Background()); !errors.As(err, &watchErr) {
+			t.Fatalf("expected JobWatchError but got %+v", err)
+		}
+	})
+	t.Run("re-establish watch for expired resource version", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		clientset := fake.NewSimpleClientset()
+		var watchCount int
+		clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+			watchCount++
+			watcher := watch.NewFake()
+			if watchCount == 1 {
+				go func() {
+					watcher.Error(&metav1.Status{
+						Status:  metav1.StatusFailure,
+						Code:    410,
+						Reason:  metav1.StatusReasonExpired,
+						Message: "too old resource version",
+					})
+				}()
+			} else {
+				go func() {
+					watcher.Modify(&apiv1.Pod{
+						ObjectMeta: metav1.ObjectMeta{Name: "test"},
+						Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+					})
+				}()
+			}
+			return true, watcher, nil
+		})
+		job.SetClientset(clientset, "default")
+		job.DisableContainerLog()
+		job.DisableCommandLog()
+		if err := job.Wait(context.Background()); err != nil {
+			t.Fatalf("failed to wait: %+v", err)
+		}
+		if watchCount != 2 {
+			t.Fatalf("expected to re-establish watch: %d", watchCount)
+		}
+	})
+}
+
+func Test_RestartPolicyOnFailure(t *testing.T) {
+	backoffLimit := int32(3)
+	job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubejob-",
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					RestartPolicy: apiv1.RestartPolicyOnFailure,
+					Containers: []apiv1.Container{
+						{
+							Name:    "test",
+							Image:   goImageName,
+							Command: []string{"sh", "-c", "if [ -f /data/retried ]; then echo ok; else touch /data/retried; exit 1; fi"},
+							VolumeMounts: []apiv1.VolumeMount{
+								{Name: "data", MountPath: "/data"},
+							},
+						},
+					},
+					Volumes: []apiv1.Volume{
+						{
+							Name:         "data",
+							VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{}},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("expected to succeed on the second try: %+v", err)
+	}
+}
+
+func Test_SetRestartHandler(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"false"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	restartingPod := func(phase apiv1.PodPhase, count int32) *apiv1.Pod {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Status: apiv1.PodStatus{
+				Phase: phase,
+				ContainerStatuses: []apiv1.ContainerStatus{
+					{Name: "test", RestartCount: count},
+				},
+			},
+		}
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{
+		restartingPod(apiv1.PodPending, 0),
+		restartingPod(apiv1.PodPending, 1),
+		// the same restart count must not be notified twice.
+		restartingPod(apiv1.PodPending, 1),
+		restartingPod(apiv1.PodPending, 2),
+		restartingPod(apiv1.PodFailed, 3),
+	})
+	job.SetClientset(clientset, "default")
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+	var counts []int32
+	job.SetRestartHandler(func(container string, count int32) {
+		if container != "test" {
+			t.Errorf("unexpected container name: %s", container)
+		}
+		counts = append(counts, count)
+	})
+	var failedJob *kubejob.FailedJob
+	if err := job.Wait(context.Background()); !errors.As(err, &failedJob) {
+		t.Fatalf("expected FailedJob but got %+v", err)
+	}
+	if fmt.Sprint(counts) != "[1 2 3]" {
+		t.Fatalf("unexpected restart counts: %v", counts)
+	}
+}
+
+func Test_SetKeepAliveAfterHandler(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.SetKeepAliveAfterHandler(10 * time.Second)
+	handlerDone := make(chan struct{})
+	aliveErrCh := make(chan error, 1)
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		for _, exec := range executors {
+			if _, err := exec.Exec(); err != nil {
+				return err
+			}
+		}
+		exec := executors[0]
+		go func() {
+			<-handlerDone
+			out, err := exec.ExecCommand("echo", "alive")
+			if err == nil && string(out) != "alive\n" {
+				err = fmt.Errorf("unexpected output %q", string(out))
+			}
+			aliveErrCh <- err
+		}()
+		close(handlerDone)
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := <-aliveErrCh; err != nil {
+		t.Fatalf("expected the container to be alive after the handler returns: %+v", err)
+	}
+}
+
+func Test_SetProbes(t *testing.T) {
+	probe := func(path string) *apiv1.Probe {
+		return &apiv1.Probe{
+			Handler: apiv1.Handler{
+				Exec: &apiv1.ExecAction{Command: []string{"test", "-f", path}},
+			},
+		}
+	}
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		SetReadinessProbe(probe("/tmp/ready")).
+		SetLivenessProbe(probe("/tmp/live")).
+		SetStartupProbe(probe("/tmp/started")).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	container := job.Spec.Template.Spec.Containers[0]
+	if container.ReadinessProbe == nil || container.ReadinessProbe.Exec.Command[2] != "/tmp/ready" {
+		t.Fatalf("failed to set readiness probe: %+v", container.ReadinessProbe)
+	}
+	if container.LivenessProbe == nil || container.LivenessProbe.Exec.Command[2] != "/tmp/live" {
+		t.Fatalf("failed to set liveness probe: %+v", container.LivenessProbe)
+	}
+	if container.StartupProbe == nil || container.StartupProbe.Exec.Command[2] != "/tmp/started" {
+		t.Fatalf("failed to set startup probe: %+v", container.StartupProbe)
+	}
+}
+
+func Test_SetAuditHandler(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	var entries []kubejob.AuditEntry
+	job.SetAuditHandler(func(entry kubejob.AuditEntry) {
+		entries = append(entries, entry)
+	})
+	srcFile := filepath.Join(t.TempDir(), "audit.txt")
+	if err := os.WriteFile(srcFile, []byte("audit"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		for _, exec := range executors {
+			if err := exec.CopyToPod(srcFile, "/tmp/audit.txt"); err != nil {
+				return err
+			}
+			if _, err := exec.ExecCommand("cat", "/tmp/audit.txt"); err != nil {
+				return err
+			}
+			if _, err := exec.Exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var (
+		foundCopy    bool
+		foundAdhoc   bool
+		foundCommand bool
+	)
+	for _, entry := range entries {
+		if entry.Timestamp.IsZero() || entry.Container == "" {
+			t.Fatalf("invalid audit entry: %+v", entry)
+		}
+		switch entry.Verb {
+		case kubejob.AuditVerbCopy:
+			if entry.SrcPath == srcFile && entry.DstPath == "pod:/tmp/audit.txt" {
+				foundCopy = true
+			}
+		case kubejob.AuditVerbExec:
+			switch strings.Join(entry.Command, " ") {
+			case "cat /tmp/audit.txt":
+				foundAdhoc = true
+			case "echo hello":
+				foundCommand = true
+			}
+		}
+	}
+	if !foundCopy || !foundAdhoc || !foundCommand {
+		t.Fatalf("failed to audit operations: %+v", entries)
+	}
+}
+
+func Test_AddInitContainer(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "main"}).
+		AddInitContainer(apiv1.Container{
+			Name:    "init",
+			Image:   goImageName,
+			Command: []string{"echo", "init"},
+		}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	initContainers := job.Spec.Template.Spec.InitContainers
+	if len(initContainers) != 1 || initContainers[0].Name != "init" {
+		t.Fatalf("failed to add init container: %+v", initContainers)
+	}
+	var logs []string
+	job.DisableInitCommandLog()
+	job.DisableCommandLog()
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		if cl.IsFinished {
+			return
+		}
+		logs = append(logs, strings.TrimSpace(cl.Log))
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if strings.Join(logs, ",") != "init,main" {
+		t.Fatalf("unexpected order: %v", logs)
+	}
+}
+
+func Test_NamespaceNotFoundError(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "missing").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "missing")
+	})
+	job.SetClientset(clientset, "missing")
+	var nsErr *kubejob.NamespaceNotFoundError
+	if err := job.Run(context.Background()); !errors.As(err, &nsErr) {
+		t.Fatalf("expected NamespaceNotFoundError but got %+v", err)
+	}
+	if nsErr.Namespace != "missing" {
+		t.Fatalf("unexpected namespace: %s", nsErr.Namespace)
+	}
+}
+
+func Test_SpreadAcrossNodes(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		SpreadAcrossNodes().
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	affinity := job.Spec.Template.Spec.Affinity
+	if affinity == nil || affinity.PodAntiAffinity == nil {
+		t.Fatal("failed to set pod anti-affinity")
+	}
+	terms := affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(terms) != 1 {
+		t.Fatalf("unexpected terms: %+v", terms)
+	}
+	term := terms[0].PodAffinityTerm
+	trackingLabel := job.Spec.Template.Labels[kubejob.SelectorLabel]
+	if trackingLabel == "" || term.LabelSelector.MatchLabels[kubejob.SelectorLabel] != trackingLabel {
+		t.Fatalf("unexpected label selector: %+v", term.LabelSelector)
+	}
+	if term.TopologyKey != apiv1.LabelHostname {
+		t.Fatalf("unexpected topology key: %s", term.TopologyKey)
+	}
+}
+
+func Test_SetLogContainers(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubejob-",
+		},
+		Spec: batchv1.JobSpec{
+			Template: apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					Containers: []apiv1.Container{
+						{
+							Name:    "main",
+							Image:   goImageName,
+							Command: []string{"echo", "main"},
+						},
+						{
+							Name:    "chatty",
+							Image:   goImageName,
+							Command: []string{"echo", "chatty"},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.SetLogContainers("main")
+	var (
+		logs   []string
+		logsMu sync.Mutex
+	)
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		logsMu.Lock()
+		defer logsMu.Unlock()
+		logs = append(logs, cl.Container.Name)
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if len(logs) == 0 {
+		t.Fatal("failed to get logs of the selected container")
+	}
+	for _, name := range logs {
+		if name != "main" {
+			t.Fatalf("unexpected log of %s", name)
+		}
+	}
+}
+
+func Test_SetGenerateName(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		SetGenerateName("custom-prefix-").
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}})
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		// fake client doesn't assign the name from GenerateName.
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		if job.Name == "" {
+			job.Name = job.GenerateName + "abcde"
+		}
+		return false, nil, nil
+	})
+	var deletedJobName string
+	clientset.PrependReactor("delete", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deletedJobName = action.(k8stesting.DeleteAction).GetName()
+		return false, nil, nil
+	})
+	job.SetClientset(clientset, "default")
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	createdName := job.CreatedJob().Name
+	if !strings.HasPrefix(createdName, "custom-prefix-") {
+		t.Fatalf("unexpected job name: %s", createdName)
+	}
+	if deletedJobName != createdName {
+		t.Fatalf("expected to cleanup %s but deleted %s", createdName, deletedJobName)
+	}
+}
+
+func Test_OOMKilledError(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{
+				{
+					Name: "test",
+					Resources: apiv1.ResourceRequirements{
+						Limits: apiv1.ResourceList{
+							apiv1.ResourceMemory: resource.MustParse("64Mi"),
+						},
+					},
+				},
+			},
+		},
+		Status: apiv1.PodStatus{
+			Phase: apiv1.PodFailed,
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{
+					Name: "test",
+					State: apiv1.ContainerState{
+						Terminated: &apiv1.ContainerStateTerminated{
+							Reason:   "OOMKilled",
+							ExitCode: 137,
+						},
+					},
+				},
+			},
+		},
+	}})
+	job.SetClientset(clientset, "default")
+	job.SetLogContainers()
+	err = job.Wait(context.Background())
+	var oomErr *kubejob.OOMKilledError
+	if !errors.As(err, &oomErr) {
+		t.Fatalf("expected OOMKilledError but got %+v", err)
+	}
+	if oomErr.Container != "test" {
+		t.Fatalf("unexpected container: %s", oomErr.Container)
+	}
+	if oomErr.Limit.String() != "64Mi" {
+		t.Fatalf("unexpected limit: %s", oomErr.Limit.String())
+	}
+	var failedJob *kubejob.FailedJob
+	if !errors.As(err, &failedJob) {
+		t.Fatalf("expected to be handled as FailedJob: %+v", err)
+	}
+}
+
+func Test_SetInitExecutionHandlers(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "main"}).
+		AddInitContainer(apiv1.Container{
+			Name:    "init-a",
+			Image:   goImageName,
+			Command: []string{"echo", "a"},
+		}).
+		AddInitContainer(apiv1.Container{
+			Name:    "init-b",
+			Image:   goImageName,
+			Command: []string{"echo", "b"},
+		}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	var called []string
+	handler := func(name string) func(*kubejob.JobExecutor) error {
+		return func(exec *kubejob.JobExecutor) error {
+			if exec.Container.Name != name {
+				return fmt.Errorf("unexpected container %s for handler of %s", exec.Container.Name, name)
+			}
+			out, err := exec.Exec()
+			if err != nil {
+				return err
+			}
+			called = append(called, fmt.Sprintf("%s:%s", name, strings.TrimSpace(string(out))))
+			return nil
+		}
+	}
+	if err := job.SetInitExecutionHandlers(map[string]func(*kubejob.JobExecutor) error{
+		"init-a": handler("init-a"),
+		"init-b": handler("init-b"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		for _, exec := range executors {
+			if _, err := exec.Exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if strings.Join(called, ",") != "init-a:a,init-b:b" {
+		t.Fatalf("unexpected handler calls: %v", called)
+	}
+}
+
+func Test_SetInitExecutionHandlersWithUnknownName(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "main"}).
+		AddInitContainer(apiv1.Container{
+			Name:    "init-a",
+			Image:   goImageName,
+			Command: []string{"echo", "a"},
+		}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	handler := func(exec *kubejob.JobExecutor) error {
+		_, err := exec.Exec()
+		return err
+	}
+	err = job.SetInitExecutionHandlers(map[string]func(*kubejob.JobExecutor) error{
+		"init-a":    handler,
+		"init-typo": handler,
+	})
+	if err == nil {
+		t.Fatal("expected error for unknown init container name")
+	}
+	if !strings.HasSuffix(err.Error(), "unknown init container names: init-typo") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func Test_SetStreamProtocols(t *testing.T) {
+	t.Run("unknown protocol", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{Host: "http://localhost"}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := job.SetStreamProtocols([]string{"v5.unknown.k8s.io"}); err == nil {
+			t.Fatal("expected error for unknown protocol")
+		}
+		if err := job.SetStreamProtocols(nil); err == nil {
+			t.Fatal("expected error for empty protocols")
+		}
+	})
+	t.Run("use configured protocols", func(t *testing.T) {
+		var (
+			mu        sync.Mutex
+			requested []string
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requested = append(requested, r.Header.Values("X-Stream-Protocol-Version")...)
+			mu.Unlock()
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		protocols := []string{"v4.channel.k8s.io", "v3.channel.k8s.io"}
+		if err := job.SetStreamProtocols(protocols); err != nil {
+			t.Fatal(err)
+		}
+		pod := &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+		exec := job.NewExecutor(pod, apiv1.Container{Name: "test"})
+		if _, err := exec.ExecOnce([]string{"echo", "hello"}); err == nil {
+			t.Fatal("expected error")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.Join(requested, ",") != strings.Join(protocols, ",") {
+			t.Fatalf("unexpected requested protocols: %v", requested)
+		}
+	})
+}
+
+func Test_NegotiatedProtocol(t *testing.T) {
+	// the server doesn't return the negotiated protocol, so the client falls back to V1.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn := spdystream.NewResponseUpgrader().UpgradeResponse(w, r, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+			go func() {
+				<-replySent
+				switch stream.Headers().Get(apiv1.StreamType) {
+				case apiv1.StreamTypeStdout:
+					stream.Write([]byte("hello"))
+					stream.Close()
+				case apiv1.StreamTypeStderr:
+					stream.Close()
+				}
+			}()
+			return nil
+		})
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		<-conn.CloseChan()
+	}))
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+	exec := job.NewExecutor(pod, apiv1.Container{Name: "test"})
+	if exec.NegotiatedProtocol() != "" {
+		t.Fatalf("unexpected negotiated protocol before exec: %s", exec.NegotiatedProtocol())
+	}
+	out, err := exec.ExecOnce([]string{"echo", "hello"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(out) != "hello" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	if exec.NegotiatedProtocol() != "channel.k8s.io" {
+		t.Fatalf("unexpected negotiated protocol: %s", exec.NegotiatedProtocol())
+	}
+}
+
+func Test_RunAll(t *testing.T) {
+	var jobs []*kubejob.Job
+	for i := 0; i < 4; i++ {
+		cmd := []string{"echo", fmt.Sprint(i)}
+		if i == 2 {
+			cmd = []string{"false"}
+		}
+		job, err := kubejob.NewJobBuilder(cfg, "default").
+			SetImage(goImageName).
+			SetCommand(cmd).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	results, err := kubejob.RunAll(context.Background(), jobs, 2)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(results) != len(jobs) {
+		t.Fatalf("unexpected result count: %d", len(results))
+	}
+	for idx, result := range results {
+		if result.Job != jobs[idx] {
+			t.Fatalf("unexpected job order at %d", idx)
+		}
+		if idx == 2 {
+			var failedJob *kubejob.FailedJob
+			if !errors.As(result.Err, &failedJob) {
+				t.Fatalf("expected FailedJob error but got %v", result.Err)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Fatalf("unexpected error at %d: %+v", idx, result.Err)
+		}
+	}
+	t.Run("invalid concurrency", func(t *testing.T) {
+		if _, err := kubejob.RunAll(context.Background(), jobs, 0); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func Test_RunAllWithCancelledContext(t *testing.T) {
+	var createCount int32
+	var jobs []*kubejob.Job
+	for i := 0; i < 10; i++ {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", fmt.Sprint(i)}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		clientset := newFakeClientset([]*apiv1.Pod{{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+		}})
+		clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			atomic.AddInt32(&createCount, 1)
+			return false, nil, nil
+		})
+		job.SetClientset(clientset, "default")
+		jobs = append(jobs, job)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := kubejob.RunAll(ctx, jobs, len(jobs))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for idx, result := range results {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Fatalf("unexpected error of job %d: %v", idx, result.Err)
+		}
+	}
+	if count := atomic.LoadInt32(&createCount); count != 0 {
+		t.Fatalf("expected not to start the jobs after cancellation: %d jobs are created", count)
+	}
+}
+
+func Test_SetHostNetwork(t *testing.T) {
+	t.Run("set dns policy together", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			SetHostNetwork(true).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		spec := job.Spec.Template.Spec
+		if !spec.HostNetwork {
+			t.Fatal("expected host network is enabled")
+		}
+		if spec.DNSPolicy != apiv1.DNSClusterFirstWithHostNet {
+			t.Fatalf("unexpected dns policy: %s", spec.DNSPolicy)
+		}
+	})
+	t.Run("keep explicit dns policy", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetHostNetwork(true).
+			AddMutator(func(job *batchv1.Job) error {
+				job.Spec.Template.Spec.DNSPolicy = apiv1.DNSDefault
+				return nil
+			}).
+			BuildWithJob(&batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Spec: batchv1.JobSpec{
+					Template: apiv1.PodTemplateSpec{
+						Spec: apiv1.PodSpec{
+							Containers: []apiv1.Container{{Name: "test", Image: goImageName, Command: []string{"echo"}}},
+						},
+					},
+				},
+			})
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if policy := job.Spec.Template.Spec.DNSPolicy; policy != apiv1.DNSDefault {
+			t.Fatalf("unexpected dns policy: %s", policy)
+		}
+	})
+	t.Run("keep host network of the job", func(t *testing.T) {
+		// the spec accepted by the API server must not be rejected if SetHostNetwork is not used.
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			BuildWithJob(&batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Spec: batchv1.JobSpec{
+					Template: apiv1.PodTemplateSpec{
+						Spec: apiv1.PodSpec{
+							HostNetwork: true,
+							DNSPolicy:   apiv1.DNSClusterFirst,
+							Containers: []apiv1.Container{{
+								Name:    "test",
+								Image:   goImageName,
+								Command: []string{"echo"},
+								Ports:   []apiv1.ContainerPort{{ContainerPort: 8080, HostPort: 8080}},
+							}},
+						},
+					},
+				},
+			})
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		spec := job.Spec.Template.Spec
+		if !spec.HostNetwork || spec.DNSPolicy != apiv1.DNSClusterFirst {
+			t.Fatalf("unexpected host network configuration: %t %s", spec.HostNetwork, spec.DNSPolicy)
+		}
+	})
+	t.Run("disabled by default", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if job.Spec.Template.Spec.HostNetwork || job.Spec.Template.Spec.DNSPolicy != "" {
+			t.Fatal("unexpected host network configuration")
+		}
+	})
+}
+
+func Test_SetTrackingLabel(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	var invalidLabelErr *kubejob.InvalidLabelError
+	if err := job.SetTrackingLabel("invalid key!", "value"); !errors.As(err, &invalidLabelErr) {
+		t.Fatalf("expected InvalidLabelError but got %v", err)
+	}
+	if err := job.SetTrackingLabel("example.com/run-id", "run-1"); err != nil {
+		t.Fatal(err)
+	}
+	if v := job.Spec.Template.Labels["example.com/run-id"]; v != "run-1" {
+		t.Fatalf("unexpected tracking label value: %q", v)
+	}
+	clientset := fake.NewSimpleClientset(
+		&apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "tracked",
+				Namespace: "default",
+				Labels:    map[string]string{"example.com/run-id": "run-1"},
+			},
+		},
+		&apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "other",
+				Namespace: "default",
+				Labels:    map[string]string{kubejob.SelectorLabel: job.Spec.Template.Labels[kubejob.SelectorLabel]},
+			},
+		},
+	)
+	var (
+		mu        sync.Mutex
+		selectors []string
+	)
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		mu.Lock()
+		selectors = append(selectors, action.(k8stesting.WatchAction).GetWatchRestrictions().Labels.String())
+		mu.Unlock()
+		watcher := watch.NewFake()
+		go func() {
+			watcher.Modify(&apiv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "tracked"},
+				Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+			})
+		}()
+		return true, watcher, nil
+	})
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		selectors = append(selectors, action.(k8stesting.ListAction).GetListRestrictions().Labels.String())
+		mu.Unlock()
+		return false, nil, nil
+	})
+	job.SetClientset(clientset, "default")
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(selectors) == 0 {
+		t.Fatal("failed to get label selectors")
+	}
+	for _, selector := range selectors {
+		if selector != "example.com/run-id=run-1" {
+			t.Fatalf("unexpected label selector: %s", selector)
+		}
+	}
+	if _, err := clientset.CoreV1().Pods("default").Get(context.Background(), "tracked", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected to delete the tracked pod: %v", err)
+	}
+	if _, err := clientset.CoreV1().Pods("default").Get(context.Background(), "other", metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected not to delete the pod selected by the generated label: %v", err)
+	}
+}
+
+func Test_LogStreamAfterRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "hello")
+	}))
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{Name: "test"}},
+		},
+		Status: apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}
+	job.SetClientset(newFakeClientset([]*apiv1.Pod{pod}, pod.DeepCopy()), "default")
+	job.SetLogger(func(string) {})
+	var logs []string
+	job.SetContainerLogger(func(log *kubejob.ContainerLog) {
+		logs = append(logs, log.Log)
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	received := len(logs)
+	// the log stream started by the watch loop that is still running after Run returns
+	// must not send logs to the closed channel.
+	if err := job.LogStreamContainer(context.Background(), pod, pod.Spec.Containers[0]); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(logs) != received {
+		t.Fatalf("unexpected logs after Run: %v", logs[received:])
+	}
+}
+
+func Test_ReadLogsAfterCompletion(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		follows []string
+	)
+	// emulate the pod completed before the log stream is opened, so the follow stream reads nothing.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/pods/test/log" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		follow := r.URL.Query().Get("follow")
+		mu.Lock()
+		follows = append(follows, follow)
+		mu.Unlock()
+		if follow == "true" {
+			return
+		}
+		fmt.Fprintln(w, "hello")
+	}))
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{Name: "test"}},
+		},
+		Status: apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{pod}, pod.DeepCopy())
+	job.SetClientset(clientset, "default")
+	job.DisableCommandLog()
+	var logs []string
+	job.SetContainerLogger(func(log *kubejob.ContainerLog) {
+		if log.IsFinished {
+			return
+		}
+		logs = append(logs, log.Log)
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if strings.Join(logs, "") != "hello\n" {
+		t.Fatalf("failed to capture logs: %q", logs)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(follows, ",") != "true," {
+		t.Fatalf("unexpected log requests: %v", follows)
+	}
+}
+
+func Test_VolumeSubPath(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		AddSharedVolume("shared", "/data", "a", "a").
+		AddSharedVolume("shared", "/data", "b", "b").
+		MountSecretSubPath("credentials", "/etc/credentials/token", "token").
+		BuildWithJob(&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Spec: batchv1.JobSpec{
+				Template: apiv1.PodTemplateSpec{
+					Spec: apiv1.PodSpec{
+						Containers: []apiv1.Container{
+							{Name: "a", Image: goImageName, Command: []string{"echo", "a"}},
+							{Name: "b", Image: goImageName, Command: []string{"echo", "b"}},
+						},
+					},
+				},
+			},
+		})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	spec := job.Spec.Template.Spec
+	if len(spec.Volumes) != 2 {
+		t.Fatalf("unexpected volumes: %+v", spec.Volumes)
+	}
+	if spec.Volumes[0].Name != "shared" || spec.Volumes[0].EmptyDir == nil {
+		t.Fatalf("unexpected shared volume: %+v", spec.Volumes[0])
+	}
+	if spec.Volumes[1].Secret == nil || spec.Volumes[1].Secret.SecretName != "credentials" {
+		t.Fatalf("unexpected secret volume: %+v", spec.Volumes[1])
+	}
+	for _, container := range spec.Containers {
+		mounts := container.VolumeMounts
+		if len(mounts) != 2 {
+			t.Fatalf("unexpected volume mounts of %s: %+v", container.Name, mounts)
+		}
+		if mounts[0].Name != "shared" || mounts[0].MountPath != "/data" || mounts[0].SubPath != container.Name {
+			t.Fatalf("unexpected shared volume mount of %s: %+v", container.Name, mounts[0])
+		}
+		if mounts[1].MountPath != "/etc/credentials/token" || mounts[1].SubPath != "token" || !mounts[1].ReadOnly {
+			t.Fatalf("unexpected secret volume mount of %s: %+v", container.Name, mounts[1])
+		}
+	}
+}
+
+func Test_SetParallelismRuntime(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if err := job.SetParallelismRuntime(context.Background(), 2); err == nil {
+		t.Fatal("expected error before the job is created")
+	}
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Name = "test-job"
+		return false, nil, nil
+	})
+	watcher := watch.NewFake()
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		return true, watcher, nil
+	})
+	newPod := func(name string, phase apiv1.PodPhase) *apiv1.Pod {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: apiv1.PodSpec{
+				Containers: []apiv1.Container{{Name: "test"}},
+			},
+			Status: apiv1.PodStatus{Phase: phase},
+		}
+	}
+	job.SetClientset(clientset, "default")
+	job.SetLogContainers()
+	created := make(chan struct{})
+	job.SetCreatedHandler(func(*batchv1.Job) { close(created) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	statusCh, errCh := job.RunWithStatusChannel(ctx)
+	var (
+		mu       sync.Mutex
+		observed = map[string][]apiv1.PodPhase{}
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for status := range statusCh {
+			mu.Lock()
+			observed[status.Pod.Name] = append(observed[status.Pod.Name], status.Phase)
+			mu.Unlock()
+		}
+	}()
+	<-created
+	watcher.Modify(newPod("pod-1", apiv1.PodRunning))
+	if err := job.SetParallelismRuntime(ctx, 2); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	patched, err := clientset.BatchV1().Jobs("default").Get(ctx, "test-job", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Spec.Parallelism == nil || *patched.Spec.Parallelism != 2 {
+		t.Fatalf("failed to patch parallelism: %v", patched.Spec.Parallelism)
+	}
+	watcher.Modify(newPod("pod-2", apiv1.PodRunning))
+	watcher.Modify(newPod("pod-1", apiv1.PodSucceeded))
+	// the Job must be still running because pod-2 has not finished yet.
+	select {
+	case err := <-errCh:
+		t.Fatalf("unexpected finish of the job: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	watcher.Modify(newPod("pod-2", apiv1.PodSucceeded))
+	patched.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobComplete, Status: apiv1.ConditionTrue},
+	}
+	if _, err := clientset.BatchV1().Jobs("default").UpdateStatus(ctx, patched, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("%+v", err)
+	}
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range []string{"pod-1", "pod-2"} {
+		phases := observed[name]
+		if len(phases) != 2 || phases[0] != apiv1.PodRunning || phases[1] != apiv1.PodSucceeded {
+			t.Fatalf("unexpected tracked phases of %s: %v", name, phases)
+		}
+	}
+}
+
+func Test_SetParallelismRuntimeScaleDown(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Name = "test-job"
+		return false, nil, nil
+	})
+	watcher := watch.NewFake()
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		return true, watcher, nil
+	})
+	newPod := func(name string, phase apiv1.PodPhase) *apiv1.Pod {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: apiv1.PodSpec{
+				Containers: []apiv1.Container{{Name: "test"}},
+			},
+			Status: apiv1.PodStatus{Phase: phase},
+		}
+	}
+	job.SetClientset(clientset, "default")
+	job.SetLogContainers()
+	created := make(chan struct{})
+	job.SetCreatedHandler(func(*batchv1.Job) { close(created) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- job.Run(ctx)
+	}()
+	<-created
+	if err := job.SetParallelismRuntime(ctx, 2); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	watcher.Modify(newPod("pod-1", apiv1.PodRunning))
+	watcher.Modify(newPod("pod-2", apiv1.PodRunning))
+	if err := job.SetParallelismRuntime(ctx, 1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// the Job controller deletes the pod to scale down.
+	watcher.Delete(newPod("pod-2", apiv1.PodRunning))
+	select {
+	case err := <-errCh:
+		t.Fatalf("unexpected finish of the job by the scale down: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	watcher.Modify(newPod("pod-1", apiv1.PodSucceeded))
+	if err := <-errCh; err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+func Test_CopyStats(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "kubejob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for path, size := range map[string]int{
+		"a.txt":     10,
+		"b.txt":     20,
+		"sub/c.txt": 30,
+	} {
+		if err := os.WriteFile(filepath.Join(srcDir, path), []byte(strings.Repeat("x", size)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dstDir, err := os.MkdirTemp("", "kubejob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dstDir)
+
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		exec := executors[0]
+		toStats, err := exec.CopyToPodStats(srcDir, "/tmp/copy-stats")
+		if err != nil {
+			return err
+		}
+		if toStats.Files != 3 || toStats.Bytes != 60 {
+			return fmt.Errorf("unexpected stats of copy to pod: %+v", toStats)
+		}
+		fromStats, err := exec.CopyFromPodStats("/tmp/copy-stats", filepath.Join(dstDir, "copy-stats"))
+		if err != nil {
+			return err
+		}
+		if fromStats.Files != 3 || fromStats.Bytes != 60 {
+			return fmt.Errorf("unexpected stats of copy from pod: %+v", fromStats)
+		}
+		if fromStats.Duration <= 0 {
+			return fmt.Errorf("unexpected duration of copy from pod: %s", fromStats.Duration)
+		}
+		_, err = exec.Exec()
+		return err
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func Test_CommandTimeout(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"sleep", "30"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	var elapsed time.Duration
+	err = job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		exec := executors[0]
+		exec.SetCommandTimeout(2 * time.Second)
+		start := time.Now()
+		_, err := exec.Exec()
+		elapsed = time.Since(start)
+		return err
+	})
+	var timeoutErr *kubejob.CommandTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected CommandTimeoutError but got %+v", err)
+	}
+	if timeoutErr.Timeout != 2*time.Second {
+		t.Fatalf("unexpected timeout: %s", timeoutErr.Timeout)
+	}
+	if elapsed > 20*time.Second {
+		t.Fatalf("command was not killed by the timeout: %s", elapsed)
+	}
+}
+
+func Test_NewExecutor(t *testing.T) {
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	pod, err := clientset.CoreV1().Pods("default").Create(ctx, &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "kubejob-executor-"},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{
+				{
+					Name:    "test",
+					Image:   goImageName,
+					Command: []string{"sleep", "300"},
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientset.CoreV1().Pods("default").Delete(ctx, pod.Name, metav1.DeleteOptions{
+		GracePeriodSeconds: new(int64),
+	})
+	for {
+		p, err := clientset.CoreV1().Pods("default").Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Status.Phase == apiv1.PodRunning {
+			break
+		}
+		time.Sleep(1 * time.Second)
+	}
+	if _, err := kubejob.NewExecutor(cfg, "default", pod.Name, "unknown"); err == nil {
+		t.Fatal("expected error for unknown container")
+	}
+	exec, err := kubejob.NewExecutor(cfg, "default", pod.Name, "test")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	out, err := exec.ExecCommand("echo", "hello")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if strings.TrimSpace(string(out)) != "hello" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	exec.SetCommand([]string{"hostname"})
+	out, err = exec.Exec()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if strings.TrimSpace(string(out)) != pod.Name {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func Test_DeadlineExceededErrorByJobCondition(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"sleep", "60"}).
+		SetActiveDeadline(3 * time.Second).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	if sec := job.Spec.ActiveDeadlineSeconds; sec == nil || *sec != 3 {
+		t.Fatalf("unexpected active deadline seconds: %v", sec)
+	}
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Name = "test-job"
+		job.Status.Conditions = []batchv1.JobCondition{
+			{
+				Type:    batchv1.JobFailed,
+				Status:  apiv1.ConditionTrue,
+				Reason:  "DeadlineExceeded",
+				Message: "Job was active longer than specified deadline",
+			},
+		}
+		return false, nil, nil
+	})
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watcher := watch.NewFake()
+		go func() {
+			watcher.Delete(&apiv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Status:     apiv1.PodStatus{Phase: apiv1.PodRunning},
+			})
+		}()
+		return true, watcher, nil
+	})
+	job.SetClientset(clientset, "default")
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+	err = job.Run(context.Background())
+	var deadlineErr *kubejob.DeadlineExceededError
+	if !errors.As(err, &deadlineErr) {
+		t.Fatalf("expected DeadlineExceededError but got %+v", err)
+	}
+	if deadlineErr.Deadline != 3*time.Second {
+		t.Fatalf("unexpected deadline: %s", deadlineErr.Deadline)
+	}
+	var failedJob *kubejob.FailedJob
+	if !errors.As(err, &failedJob) {
+		t.Fatalf("expected to be handled as FailedJob: %+v", err)
+	}
+}
+
+func Test_DeadlineExceededError(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"sleep", "60"}).
+		SetActiveDeadline(5 * time.Second).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.DisableContainerLog()
+	var deadlineErr *kubejob.DeadlineExceededError
+	if err := job.Run(context.Background()); !errors.As(err, &deadlineErr) {
+		t.Fatalf("expected DeadlineExceededError but got %+v", err)
+	}
+}
+
+func Test_ExecStream(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocol, err := httpstream.Handshake(r, w, []string{"v4.channel.k8s.io"})
+		if err != nil {
+			return
+		}
+		if protocol != "v4.channel.k8s.io" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// the command to stop the container finishes instantly.
+		isStopCommand := strings.Contains(strings.Join(r.URL.Query()["command"], " "), "kubejob-status")
+		stdoutDone := make(chan struct{})
+		conn := spdystream.NewResponseUpgrader().UpgradeResponse(w, r, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+			go func() {
+				<-replySent
+				switch stream.Headers().Get(apiv1.StreamType) {
+				case apiv1.StreamTypeStdout:
+					defer close(stdoutDone)
+					defer stream.Close()
+					if isStopCommand {
+						return
+					}
+					stream.Write([]byte("line1\n"))
+					// the next line is written after the callback received the first line.
+					select {
+					case <-received:
+					case <-time.After(5 * time.Second):
+						return
+					}
+					stream.Write([]byte("line2\nlast"))
+				case apiv1.StreamTypeStderr:
+					if !isStopCommand {
+						stream.Write([]byte("error\n"))
+					}
+					stream.Close()
+				case apiv1.StreamTypeError:
+					<-stdoutDone
+					if !isStopCommand {
+						stream.Write([]byte(`{"metadata":{},"status":"Failure","reason":"NonZeroExitCode","details":{"causes":[{"reason":"ExitCode","message":"3"}]}}`))
+					}
+					stream.Close()
+				}
+			}()
+			return nil
+		})
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		<-conn.CloseChan()
+	}))
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+	exec := job.NewExecutor(pod, apiv1.Container{Name: "test"})
+	exec.SetCommand([]string{"sh", "-c", "echo line1; sleep 1; echo line2; echo error >&2; exit 3"})
+	var lines []string
+	exitCode, err := exec.ExecStream(func(stream string, line []byte) {
+		lines = append(lines, fmt.Sprintf("%s:%s", stream, line))
+		if stream == kubejob.ExecStreamStdout && string(line) == "line1" {
+			received <- string(line)
+		}
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if exitCode != 3 {
+		t.Fatalf("unexpected exit code: %d", exitCode)
+	}
+	sort.Strings(lines)
+	expected := []string{"stderr:error", "stdout:last", "stdout:line1", "stdout:line2"}
+	if strings.Join(lines, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected lines: %v", lines)
+	}
+}
+
+func Test_SetConcurrencyKey(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var created int32
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Name = fmt.Sprintf("test-job-%d", atomic.AddInt32(&created, 1))
+		return false, nil, nil
+	})
+	firstWatcher := watch.NewFake()
+	var watchCount int32
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		if atomic.AddInt32(&watchCount, 1) == 1 {
+			return true, firstWatcher, nil
+		}
+		watcher := watch.NewFake()
+		go func() {
+			watcher.Modify(&apiv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+			})
+		}()
+		return true, watcher, nil
+	})
+	newJob := func(t *testing.T) *kubejob.Job {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		job.SetClientset(clientset, "default")
+		job.SetConcurrencyKey("nightly-report")
+		job.DisableContainerLog()
+		job.DisableCommandLog()
+		return job
+	}
+
+	first := newJob(t)
+	createdFirst := make(chan struct{})
+	first.SetCreatedHandler(func(*batchv1.Job) { close(createdFirst) })
+	firstErrCh := make(chan error, 1)
+	go func() {
+		firstErrCh <- first.Run(context.Background())
+	}()
+	<-createdFirst
+
+	var concurrentErr *kubejob.ConcurrentRunError
+	if err := newJob(t).Run(context.Background()); !errors.As(err, &concurrentErr) {
+		t.Fatalf("expected ConcurrentRunError but got %+v", err)
+	}
+	if concurrentErr.Key != "nightly-report" || concurrentErr.RunningJob != "test-job-1" {
+		t.Fatalf("unexpected error: %+v", concurrentErr)
+	}
+
+	firstWatcher.Modify(&apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	})
+	if err := <-firstErrCh; err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// the first job was finished and deleted, so the job with the same key can run.
+	if err := newJob(t).Run(context.Background()); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func Test_MountPVC(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		MountPVC("dataset", "/data", true).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	spec := job.Spec.Template.Spec
+	if len(spec.Volumes) != 1 {
+		t.Fatalf("unexpected volumes: %+v", spec.Volumes)
+	}
+	pvc := spec.Volumes[0].PersistentVolumeClaim
+	if pvc == nil || pvc.ClaimName != "dataset" || !pvc.ReadOnly {
+		t.Fatalf("unexpected pvc volume source: %+v", spec.Volumes[0])
+	}
+	mounts := spec.Containers[0].VolumeMounts
+	if len(mounts) != 1 {
+		t.Fatalf("unexpected volume mounts: %+v", mounts)
+	}
+	if mounts[0].Name != spec.Volumes[0].Name || mounts[0].MountPath != "/data" || !mounts[0].ReadOnly {
+		t.Fatalf("unexpected volume mount: %+v", mounts[0])
+	}
+}
+
+func Test_MountPVCWithLongClaimName(t *testing.T) {
+	claimNames := []string{
+		"dataset.example.com",
+		strings.Repeat("a", 70) + "-1",
+		strings.Repeat("a", 70) + "-2",
+	}
+	builder := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"})
+	for _, claimName := range claimNames {
+		builder = builder.MountPVC(claimName, "/data/"+claimName, false)
+	}
+	job, err := builder.Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	spec := job.Spec.Template.Spec
+	if len(spec.Volumes) != len(claimNames) {
+		t.Fatalf("unexpected volumes: %+v", spec.Volumes)
+	}
+	names := map[string]struct{}{}
+	for idx, volume := range spec.Volumes {
+		if errs := validation.IsDNS1123Label(volume.Name); len(errs) > 0 {
+			t.Fatalf("invalid volume name %q: %v", volume.Name, errs)
+		}
+		if volume.PersistentVolumeClaim == nil || volume.PersistentVolumeClaim.ClaimName != claimNames[idx] {
+			t.Fatalf("unexpected pvc volume source: %+v", volume)
+		}
+		if spec.Containers[0].VolumeMounts[idx].Name != volume.Name {
+			t.Fatalf("unexpected volume mount: %+v", spec.Containers[0].VolumeMounts[idx])
+		}
+		names[volume.Name] = struct{}{}
+	}
+	if len(names) != len(claimNames) {
+		t.Fatalf("volume names are conflicted: %+v", spec.Volumes)
+	}
+}
+
+func Test_DisableRestartPolicyDefault(t *testing.T) {
+	newJobSpec := func() *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Spec: batchv1.JobSpec{
+				Template: apiv1.PodTemplateSpec{
+					Spec: apiv1.PodSpec{
+						Containers: []apiv1.Container{{Name: "test", Image: goImageName, Command: []string{"echo"}}},
+					},
+				},
+			},
+		}
+	}
+	t.Run("default", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").BuildWithJob(newJobSpec())
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if policy := job.Spec.Template.Spec.RestartPolicy; policy != apiv1.RestartPolicyNever {
+			t.Fatalf("unexpected restart policy: %q", policy)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			DisableRestartPolicyDefault().
+			BuildWithJob(newJobSpec())
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if policy := job.Spec.Template.Spec.RestartPolicy; policy != "" {
+			t.Fatalf("unexpected restart policy: %q", policy)
+		}
+	})
+	t.Run("disabled with build", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			DisableRestartPolicyDefault().
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if policy := job.Spec.Template.Spec.RestartPolicy; policy != "" {
+			t.Fatalf("unexpected restart policy: %q", policy)
+		}
+	})
+	t.Run("keep specified policy", func(t *testing.T) {
+		spec := newJobSpec()
+		spec.Spec.Template.Spec.RestartPolicy = apiv1.RestartPolicyOnFailure
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").BuildWithJob(spec)
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if policy := job.Spec.Template.Spec.RestartPolicy; policy != apiv1.RestartPolicyOnFailure {
+			t.Fatalf("unexpected restart policy: %q", policy)
+		}
+	})
+}
+
+func Test_SetContextLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "hello")
+	}))
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{Name: "test"}},
+		},
+		Status: apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{pod}, pod.DeepCopy())
+	job.SetClientset(clientset, "default")
+	job.DisableCommandLog()
+
+	type correlationIDKey struct{}
+	var (
+		ids  []interface{}
+		logs []string
+	)
+	job.SetContainerLogger(func(*kubejob.ContainerLog) {
+		t.Error("container logger must not be called when the context logger is set")
+	})
+	job.SetContextLogger(func(ctx context.Context, log *kubejob.ContainerLog) {
+		ids = append(ids, ctx.Value(correlationIDKey{}))
+		if !log.IsFinished {
+			logs = append(logs, log.Log)
+		}
+	})
+	ctx := context.WithValue(context.Background(), correlationIDKey{}, "request-1")
+	if err := job.Run(ctx); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if strings.Join(logs, "") != "hello\n" {
+		t.Fatalf("unexpected logs: %q", logs)
+	}
+	if len(ids) == 0 {
+		t.Fatal("context logger was not called")
+	}
+	for _, id := range ids {
+		if id != "request-1" {
+			t.Fatalf("unexpected correlation id: %v", id)
+		}
+	}
+}
+
+func Test_AutoLabel(t *testing.T) {
+	build := func(t *testing.T, command []string) *kubejob.Job {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage("gcr.io/example/image:v1.0@sha256").
+			SetCommand(command).
+			AutoLabel(true).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		return job
+	}
+	job := build(t, []string{"sh", "-c", "echo 'hello world' | tee /tmp/out"})
+	labels := job.Spec.Template.Labels
+	if v := labels[kubejob.ImageLabel]; v != "gcr.io-example-image-v1.0-sha256" {
+		t.Fatalf("unexpected image label: %q", v)
+	}
+	hash := labels[kubejob.CommandHashLabel]
+	if len(hash) != 10 {
+		t.Fatalf("unexpected command hash label: %q", hash)
+	}
+	for _, key := range []string{kubejob.ImageLabel, kubejob.CommandHashLabel} {
+		if errs := validation.IsValidLabelValue(labels[key]); len(errs) > 0 {
+			t.Fatalf("invalid label value of %s: %v", key, errs)
+		}
+	}
+	if other := build(t, []string{"echo", "hello"}).Spec.Template.Labels[kubejob.CommandHashLabel]; other == hash {
+		t.Fatalf("expected different command hash for the different command")
+	}
+	if same := build(t, []string{"sh", "-c", "echo 'hello world' | tee /tmp/out"}).Spec.Template.Labels[kubejob.CommandHashLabel]; same != hash {
+		t.Fatalf("expected the same command hash for the same command")
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if _, exists := job.Spec.Template.Labels[kubejob.ImageLabel]; exists {
+			t.Fatal("unexpected image label")
+		}
+	})
+	t.Run("long image name", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage("registry.example.com/" + strings.Repeat("a", 100) + ":latest").
+			SetCommand([]string{"echo", "hello"}).
+			AutoLabel(true).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if errs := validation.IsValidLabelValue(job.Spec.Template.Labels[kubejob.ImageLabel]); len(errs) > 0 {
+			t.Fatalf("invalid label value: %v", errs)
+		}
+	})
+}
+
+func Test_WaitForLogPattern(t *testing.T) {
+	run := func(t *testing.T, re *regexp.Regexp) error {
+		started := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-started
+			fmt.Fprintln(w, "initializing")
+			fmt.Fprintln(w, "server started on :8080")
+		}))
+		defer server.Close()
+
+		job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		pod := &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec: apiv1.PodSpec{
+				Containers: []apiv1.Container{{Name: "test"}},
+			},
+			Status: apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+		}
+		clientset := newFakeClientset([]*apiv1.Pod{pod}, pod.DeepCopy())
+		job.SetClientset(clientset, "default")
+		job.DisableCommandLog()
+		job.SetContainerLogger(func(*kubejob.ContainerLog) {})
+
+		waitErr := make(chan error, 1)
+		go func() {
+			waitErr <- job.WaitForLogPattern(context.Background(), "test", re)
+		}()
+		go func() {
+			// start streaming the logs after WaitForLogPattern is registered.
+			for job.LogPatternWaiterCount() == 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+			close(started)
+		}()
+		if err := job.Run(context.Background()); err != nil {
+			t.Fatalf("failed to run: %+v", err)
+		}
+		select {
+		case err := <-waitErr:
+			return err
+		case <-time.After(10 * time.Second):
+			t.Fatal("WaitForLogPattern was not returned")
+		}
+		return nil
+	}
+	t.Run("matched", func(t *testing.T) {
+		if err := run(t, regexp.MustCompile(`server started`)); err != nil {
+			t.Fatalf("failed to wait for the log pattern: %+v", err)
+		}
+	})
+	t.Run("container exited", func(t *testing.T) {
+		if err := run(t, regexp.MustCompile(`ready`)); err == nil {
+			t.Fatal("expect error")
+		}
+	})
+}
+
+func Test_SetLogChannelBuffer(t *testing.T) {
+	const lineNum = 50
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < lineNum; i++ {
+			fmt.Fprintf(w, "line %d\n", i)
+		}
+	}))
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{Name: "test"}},
+		},
+		Status: apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{pod}, pod.DeepCopy())
+	job.SetClientset(clientset, "default")
+	job.DisableCommandLog()
+	job.SetLogChannelBuffer(10)
+
+	var logs []string
+	job.SetContainerLogger(func(log *kubejob.ContainerLog) {
+		// deliberately slow logger
+		time.Sleep(5 * time.Millisecond)
+		if !log.IsFinished {
+			logs = append(logs, log.Log)
+		}
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- job.Run(context.Background())
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed to run: %+v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("deadlock detected")
+	}
+	if len(logs) != lineNum {
+		t.Fatalf("failed to deliver all logs: %d", len(logs))
+	}
+	for i, log := range logs {
+		if log != fmt.Sprintf("line %d\n", i) {
+			t.Fatalf("unexpected log: %q", log)
+		}
+	}
+}
+
+func Test_SetPreHandlerHook(t *testing.T) {
+	t.Run("receive running pod", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(cfg, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		var hookedPod *apiv1.Pod
+		job.SetPreHandlerHook(func(pod *apiv1.Pod) error {
+			hookedPod = pod
+			return nil
+		})
+		if err := job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+			if hookedPod == nil {
+				return fmt.Errorf("hook was not called before the handler")
+			}
+			_, err := executors[0].Exec()
+			return err
+		}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if hookedPod.Status.Phase != apiv1.PodRunning {
+			t.Fatalf("unexpected pod phase: %s", hookedPod.Status.Phase)
+		}
+	})
+	t.Run("abort", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(cfg, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		hookErr := errors.New("abort")
+		job.SetPreHandlerHook(func(pod *apiv1.Pod) error {
+			return hookErr
+		})
+		called := false
+		err = job.RunWithExecutionHandler(context.Background(), func(executors []*kubejob.JobExecutor) error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, hookErr) {
+			t.Fatalf("expected hook error but got %+v", err)
+		}
+		if called {
+			t.Fatal("handler must not be called")
+		}
+	})
+}
+
+func Test_RunWithExecutionHandlerFailFast(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubejob-",
+		},
+		Spec: batchv1.JobSpec{
+			Template: apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					Containers: []apiv1.Container{
+						{
+							Name:    "first",
+							Image:   goImageName,
+							Command: []string{"sh", "-c", "exit 1"},
+						},
+						{
+							Name:    "second",
+							Image:   goImageName,
+							Command: []string{"echo", "executed"},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	var secondOut []byte
+	err = job.RunWithExecutionHandlerFailFast(context.Background(), func(executors []*kubejob.JobExecutor) error {
+		for _, exec := range executors {
+			out, err := exec.Exec()
+			if exec.Container.Name == "first" {
+				if err == nil {
+					t.Error("expect error")
+				}
+				continue
+			}
+			if err == nil {
+				t.Error("the second executor must not be executed")
+			}
+			secondOut = out
+		}
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expect error")
+	}
+	if strings.Contains(string(secondOut), "executed") {
+		t.Fatalf("the second executor was executed: %q", secondOut)
+	}
+}
+
+// deleteOptionsRecorder records DeleteOptions of the Job because the fake client of client-go drops them.
+type deleteOptionsRecorder struct {
+	*fake.Clientset
+	opts *metav1.DeleteOptions
+}
+
+func (r *deleteOptionsRecorder) BatchV1() typedbatchv1.BatchV1Interface {
+	return &deleteOptionsRecorderBatchV1{BatchV1Interface: r.Clientset.BatchV1(), recorder: r}
+}
+
+type deleteOptionsRecorderBatchV1 struct {
+	typedbatchv1.BatchV1Interface
+	recorder *deleteOptionsRecorder
+}
+
+func (b *deleteOptionsRecorderBatchV1) Jobs(namespace string) typedbatchv1.JobInterface {
+	return &deleteOptionsRecorderJobs{JobInterface: b.BatchV1Interface.Jobs(namespace), recorder: b.recorder}
+}
+
+type deleteOptionsRecorderJobs struct {
+	typedbatchv1.JobInterface
+	recorder *deleteOptionsRecorder
+}
+
+func (j *deleteOptionsRecorderJobs) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	j.recorder.opts = &opts
+	return j.JobInterface.Delete(ctx, name, opts)
+}
+
+func Test_SetDeletePropagation(t *testing.T) {
+	run := func(t *testing.T, propagation *metav1.DeletionPropagation) *metav1.DeleteOptions {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		clientset := newFakeClientset([]*apiv1.Pod{{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+		}})
+		clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+			if job.Name == "" {
+				job.Name = job.GenerateName + "abcde"
+			}
+			return false, nil, nil
+		})
+		recorder := &deleteOptionsRecorder{Clientset: clientset}
+		job.SetClientset(recorder, "default")
+		job.DisableContainerLog()
+		job.DisableCommandLog()
+		if propagation != nil {
+			job.SetDeletePropagation(*propagation)
+		}
+		if err := job.Run(context.Background()); err != nil {
+			t.Fatalf("failed to run: %+v", err)
+		}
+		if recorder.opts == nil {
+			t.Fatal("job was not deleted")
+		}
+		if recorder.opts.PropagationPolicy == nil {
+			t.Fatal("propagation policy was not specified")
+		}
+		return recorder.opts
+	}
+	t.Run("default", func(t *testing.T) {
+		opts := run(t, nil)
+		if *opts.PropagationPolicy != metav1.DeletePropagationBackground {
+			t.Fatalf("unexpected propagation policy: %s", *opts.PropagationPolicy)
+		}
+	})
+	t.Run("foreground", func(t *testing.T) {
+		foreground := metav1.DeletePropagationForeground
+		opts := run(t, &foreground)
+		if *opts.PropagationPolicy != metav1.DeletePropagationForeground {
+			t.Fatalf("unexpected propagation policy: %s", *opts.PropagationPolicy)
+		}
+	})
+}
+
+func Test_GetContainerLogs(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status: apiv1.PodStatus{
+			Phase: apiv1.PodSucceeded,
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{Name: "test"},
+			},
+		},
+	}})
+	job.SetClientset(clientset, "default")
+	if _, err := job.GetContainerLogs(context.Background(), "test"); err == nil {
+		t.Fatal("expected error before the pod is observed")
+	}
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+	if err := job.Wait(context.Background()); err != nil {
+		t.Fatalf("failed to wait: %+v", err)
+	}
+	logs, err := job.GetContainerLogs(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("failed to get logs: %+v", err)
+	}
+	// the fake client always returns the fixed content.
+	if string(logs) != "fake logs" {
+		t.Fatalf("unexpected logs: %q", logs)
+	}
+	var found bool
+	for _, action := range clientset.Actions() {
+		if action.GetSubresource() != "log" {
+			continue
+		}
+		opts, ok := action.(k8stesting.GenericActionImpl).Value.(*apiv1.PodLogOptions)
+		if ok && opts.Container == "test" && !opts.Follow && !opts.Previous {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected to request the logs without following")
+	}
+}
+
+func Test_BuildWithReaderEnv(t *testing.T) {
+	manifest := `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: kubejob-
+spec:
+  template:
+    spec:
+      containers:
+      - name: test
+        image: golang:${IMAGE_TAG}
+        command: ["echo", "${MESSAGE:-hello}"]
+`
+	t.Run("substitution", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").BuildWithReaderEnv(
+			strings.NewReader(manifest),
+			map[string]string{"IMAGE_TAG": "1.16", "MESSAGE": "world"},
+		)
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		container := job.Spec.Template.Spec.Containers[0]
+		if container.Image != "golang:1.16" {
+			t.Fatalf("unexpected image: %s", container.Image)
+		}
+		if container.Command[1] != "world" {
+			t.Fatalf("unexpected command: %v", container.Command)
+		}
+	})
+	t.Run("default", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").BuildWithReaderEnv(
+			strings.NewReader(manifest),
+			map[string]string{"IMAGE_TAG": "1.16"},
+		)
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if command := job.Spec.Template.Spec.Containers[0].Command; command[1] != "hello" {
+			t.Fatalf("unexpected command: %v", command)
+		}
+	})
+	t.Run("undefined variable", func(t *testing.T) {
+		_, err := kubejob.NewJobBuilder(&rest.Config{}, "default").BuildWithReaderEnv(
+			strings.NewReader(manifest),
+			map[string]string{"MESSAGE": "world"},
+		)
+		var undefinedErr *kubejob.UndefinedVariableError
+		if !errors.As(err, &undefinedErr) {
+			t.Fatalf("expected UndefinedVariableError but got %+v", err)
+		}
+		if len(undefinedErr.Names) != 1 || undefinedErr.Names[0] != "IMAGE_TAG" {
+			t.Fatalf("unexpected undefined variables: %v", undefinedErr.Names)
+		}
+	})
+}
+
+func Test_SetStdoutAndStderr(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "hello")
+	}))
+	defer server.Close()
+
+	// replace the process streams to detect the direct writes.
+	processStdout, processStdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	processStderr, processStderrW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultStdout, defaultStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = processStdoutW, processStderrW
+	defer func() {
+		os.Stdout, os.Stderr = defaultStdout, defaultStderr
+	}()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{Name: "test", Command: []string{"echo", "hello"}}},
+		},
+		Status: apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{pod}, pod.DeepCopy())
+	job.SetClientset(clientset, "default")
+	job.SetLogLevel(kubejob.LogLevelDebug)
+	var stdout, stderr bytes.Buffer
+	job.SetStdout(&stdout)
+	job.SetStderr(&stderr)
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	// the executed command is written to stdout.
+	// the command execution itself fails because the test server doesn't support exec.
+	defer kubejob.SetExecRetryCount(1)()
+	executor := job.NewExecutor(pod, pod.Spec.Containers[0])
+	executor.SetCommand([]string{"echo", "hello"})
+	_, _ = executor.ExecOnly()
+
+	processStdoutW.Close()
+	processStderrW.Close()
+	os.Stdout, os.Stderr = defaultStdout, defaultStderr
+	writtenStdout, err := io.ReadAll(processStdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writtenStderr, err := io.ReadAll(processStderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(writtenStdout) != 0 || len(writtenStderr) != 0 {
+		t.Fatalf("unexpected process output: stdout %q stderr %q", writtenStdout, writtenStderr)
+	}
+	if !strings.Contains(stdout.String(), "echo hello") {
+		t.Fatalf("unexpected stdout: %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "hello\n") || !strings.Contains(stderr.String(), "[DEBUG]") {
+		t.Fatalf("unexpected stderr: %q", stderr.String())
+	}
+}
+
+func Test_Preflight(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		// the fake client doesn't validate the spec, so return the error like the API server.
+		return true, nil, apierrors.NewInvalid(
+			schema.GroupKind{Group: "batch", Kind: "Job"},
+			"kubejob-",
+			field.ErrorList{
+				field.Invalid(
+					field.NewPath("spec", "template", "spec", "containers").Index(0).Child("resources", "limits", "memory"),
+					"-1",
+					"must be greater than or equal to 0",
+				),
+			},
+		)
+	})
+	job.SetClientset(clientset, "default")
+	err = job.Preflight(context.Background())
+	var preflightErr *kubejob.PreflightError
+	if !errors.As(err, &preflightErr) {
+		t.Fatalf("expected PreflightError but got %+v", err)
+	}
+	if !apierrors.IsInvalid(err) {
+		t.Fatalf("expected to wrap the invalid error: %+v", err)
+	}
+	if len(preflightErr.FieldErrors) != 1 {
+		t.Fatalf("unexpected field errors: %+v", preflightErr.FieldErrors)
+	}
+	fieldErr := preflightErr.FieldErrors[0]
+	if fieldErr.Field != "spec.template.spec.containers[0].resources.limits.memory" {
+		t.Fatalf("unexpected field: %s", fieldErr.Field)
+	}
+	if fieldErr.Type != string(metav1.CauseTypeFieldValueInvalid) {
+		t.Fatalf("unexpected type: %s", fieldErr.Type)
+	}
+	if job.CreatedJob() != nil {
+		t.Fatal("job must not be created by preflight")
+	}
+}
+
+func Test_ReuseSPDYTransport(t *testing.T) {
+	handler, executedCommands := newExecHandler(func(string, []string) bool { return false })
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	config := &rest.Config{
+		Host:            server.URL,
+		TLSClientConfig: rest.TLSClientConfig{Insecure: true},
+	}
+	newJob := func(t *testing.T) *kubejob.Job {
+		job, err := kubejob.NewJobBuilder(config, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		return job
+	}
+	tlsConfigOf := func(t *testing.T, exec *kubejob.JobExecutor) *tls.Config {
+		tlsConfig, err := exec.SPDYTLSConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tlsConfig
+	}
+	job := newJob(t)
+	pod := &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	executors := []*kubejob.JobExecutor{
+		job.NewExecutor(pod, apiv1.Container{Name: "first"}),
+		job.NewExecutor(pod, apiv1.Container{Name: "second"}),
+	}
+	transportOf := func(t *testing.T, exec *kubejob.JobExecutor) http.RoundTripper {
+		transport, err := exec.SPDYTransport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return transport
+	}
+	// the SPDY transport is built only once per Job and shared by the concurrent commands.
+	var eg errgroup.Group
+	for _, exec := range executors {
+		exec := exec
+		for i := 0; i < 2; i++ {
+			eg.Go(func() error {
+				_, err := exec.ExecOnce([]string{"echo", "hello"})
+				return err
+			})
+		}
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("failed to exec: %+v", err)
+	}
+	if len(executedCommands()["test"]) != 4 {
+		t.Fatalf("unexpected commands: %v", executedCommands())
+	}
+	if transportOf(t, executors[0]) != transportOf(t, executors[1]) {
+		t.Fatal("expected to reuse the same transport across executors of the Job")
+	}
+	if tlsConfigOf(t, executors[0]) != tlsConfigOf(t, executors[1]) {
+		t.Fatal("expected to reuse the same tls config across executors of the Job")
+	}
+	// the cache belongs to the Job, so it's released together with the Job.
+	other := newJob(t).NewExecutor(pod, apiv1.Container{Name: "first"})
+	if tlsConfigOf(t, other) == tlsConfigOf(t, executors[0]) {
+		t.Fatal("expected not to share the tls config across Jobs")
+	}
+	if transportOf(t, other) == transportOf(t, executors[0]) {
+		t.Fatal("expected not to share the transport across Jobs")
+	}
+}
+
+func Test_WindowsContainer(t *testing.T) {
+	run := func(t *testing.T, nodeSelector map[string]string, os kubejob.OS) *batchv1.Job {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").BuildWithJob(&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "kubejob-",
+			},
+			Spec: batchv1.JobSpec{
+				Template: apiv1.PodTemplateSpec{
+					Spec: apiv1.PodSpec{
+						NodeSelector: nodeSelector,
+						Containers: []apiv1.Container{
+							{
+								Name:    "test",
+								Image:   "mcr.microsoft.com/windows/servercore:ltsc2019",
+								Command: []string{"cmd", "/c", "echo hello"},
+							},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if os != "" {
+			job.SetOS(os)
+		}
+		var createdJob *batchv1.Job
+		clientset := newFakeClientset([]*apiv1.Pod{{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+		}})
+		clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			createdJob = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).DeepCopy()
+			return false, nil, nil
+		})
+		job.SetClientset(clientset, "default")
+		job.DisableContainerLog()
+		if err := job.RunWithExecutionHandler(context.Background(), func([]*kubejob.JobExecutor) error {
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to run: %+v", err)
+		}
+		if createdJob == nil {
+			t.Fatal("job was not created")
+		}
+		return createdJob
+	}
+	assertWindowsShim := func(t *testing.T, job *batchv1.Job) {
+		container := job.Spec.Template.Spec.Containers[0]
+		if strings.Join(container.Command, " ") != "powershell -Command" {
+			t.Fatalf("unexpected command: %v", container.Command)
+		}
+		if len(container.Args) != 1 || !strings.Contains(container.Args[0], `C:\Windows\Temp\kubejob-status`) {
+			t.Fatalf("unexpected args: %v", container.Args)
+		}
+	}
+	t.Run("detect by node selector", func(t *testing.T) {
+		assertWindowsShim(t, run(t, map[string]string{apiv1.LabelOSStable: "windows"}, ""))
+	})
+	t.Run("SetOS", func(t *testing.T) {
+		assertWindowsShim(t, run(t, nil, kubejob.OSWindows))
+	})
+	t.Run("linux", func(t *testing.T) {
+		job := run(t, map[string]string{apiv1.LabelOSStable: "linux"}, "")
+		if command := job.Spec.Template.Spec.Containers[0].Command; strings.Join(command, " ") != "sh -c" {
+			t.Fatalf("unexpected command: %v", command)
+		}
+	})
+}
+
+func Test_SetCommandLogWriter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	var stdout, commandLog bytes.Buffer
+	job.SetStdout(&stdout)
+	job.SetCommandLogWriter(&commandLog)
+	job.SetLogger(func(string) {})
+
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{Name: "test"}},
+		},
+	}
+	// the command execution itself fails because the test server doesn't support exec.
+	defer kubejob.SetExecRetryCount(1)()
+	executor := job.NewExecutor(pod, pod.Spec.Containers[0])
+	executor.SetCommand([]string{"echo", "hello"})
+	_, _ = executor.ExecOnly()
+
+	if commandLog.String() != "echo hello\n" {
+		t.Fatalf("unexpected command log: %q", commandLog.String())
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("command log must not be written to stdout: %q", stdout.String())
+	}
+}
+
+func Test_PreInitWithContext(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	job.PreInitWithContext(apiv1.Container{
+		Name:    "preinit",
+		Image:   goImageName,
+		Command: []string{"sleep", "60"},
+	}, func(ctx context.Context, exec *kubejob.JobExecutor) error {
+		if ctx == nil {
+			return fmt.Errorf("failed to get context")
+		}
+		close(started)
+		_, err := exec.Exec()
+		return err
+	})
+	go func() {
+		<-started
+		time.Sleep(2 * time.Second)
+		cancel()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		done <- job.RunWithExecutionHandler(ctx, func(executors []*kubejob.JobExecutor) error {
+			return fmt.Errorf("handler must not be called")
+		})
+	}()
+	select {
+	case <-started:
+	case err := <-done:
+		t.Fatalf("preinit was not started: %+v", err)
+	}
+	canceledAt := time.Now()
+	select {
+	case err := <-done:
+		if err != nil && strings.Contains(err.Error(), "handler must not be called") {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		if elapsed := time.Since(canceledAt); elapsed > 20*time.Second {
+			t.Fatalf("failed to abort promptly: %s", elapsed)
+		}
+	case <-time.After(50 * time.Second):
+		t.Fatal("failed to abort preinit")
+	}
+}
+
+func Test_SetLogParserAnnotation(t *testing.T) {
+	t.Run("set", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			SetLogParserAnnotation("json").
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if v := job.Spec.Template.Annotations[kubejob.FluentBitParserAnnotation]; v != "json" {
+			t.Fatalf("unexpected parser annotation: %q", v)
+		}
+	})
+	t.Run("SetAnnotations takes precedence", func(t *testing.T) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			SetAnnotations(map[string]string{kubejob.FluentBitParserAnnotation: "logfmt"}).
+			SetLogParserAnnotation("json").
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		if v := job.Spec.Template.Annotations[kubejob.FluentBitParserAnnotation]; v != "logfmt" {
+			t.Fatalf("unexpected parser annotation: %q", v)
+		}
+	})
+}
+
+func Test_PodEvictedError(t *testing.T) {
+	evictedPod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "evicted"},
+		Status: apiv1.PodStatus{
+			Phase:   apiv1.PodFailed,
+			Reason:  "Evicted",
+			Message: "The node was low on resource: memory.",
+		},
+	}
+	succeededPod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "succeeded"},
+		Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}
+	run := func(t *testing.T, retryCount int) ([]string, error) {
+		job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+			SetImage(goImageName).
+			SetCommand([]string{"echo", "hello"}).
+			Build()
+		if err != nil {
+			t.Fatalf("failed to build job: %+v", err)
+		}
+		var (
+			createdCount int32
+			watchCount   int32
+			createdNames []string
+		)
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+			if job.Name == "" {
+				job.Name = fmt.Sprintf("%s%d", job.GenerateName, atomic.AddInt32(&createdCount, 1))
+			}
+			createdNames = append(createdNames, job.Name)
+			return false, nil, nil
+		})
+		clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+			pod := evictedPod
+			if atomic.AddInt32(&watchCount, 1) > 1 {
+				pod = succeededPod
+			}
+			watcher := watch.NewFake()
+			go func() {
+				watcher.Modify(pod.DeepCopy())
+			}()
+			return true, watcher, nil
+		})
+		job.SetClientset(clientset, "default")
+		job.SetLogContainers()
+		job.SetLogger(func(string) {})
+		job.SetRetryOnEviction(retryCount)
+		return createdNames, job.Run(context.Background())
+	}
+	t.Run("evicted", func(t *testing.T) {
+		createdNames, err := run(t, 0)
+		var evictedErr *kubejob.PodEvictedError
+		if !errors.As(err, &evictedErr) {
+			t.Fatalf("expected PodEvictedError but got %+v", err)
+		}
+		if evictedErr.Message != "The node was low on resource: memory." {
+			t.Fatalf("unexpected message: %s", evictedErr.Message)
+		}
+		var failedJob *kubejob.FailedJob
+		if !errors.As(err, &failedJob) {
+			t.Fatal("expected to be handled as FailedJob")
+		}
+		if len(createdNames) != 1 {
+			t.Fatalf("unexpected created jobs: %v", createdNames)
+		}
+	})
+	t.Run("retry", func(t *testing.T) {
+		createdNames, err := run(t, 1)
+		if err != nil {
+			t.Fatalf("failed to run: %+v", err)
+		}
+		if len(createdNames) != 2 || createdNames[0] == createdNames[1] {
+			t.Fatalf("expected to recreate the job: %v", createdNames)
+		}
+	})
+}
+
+// newExecServer creates the server that emulates the exec API of the pods and records the executed commands by the pod name.
+// If fail returns true, the exec request fails by the internal error.
+func newExecServer(fail func(pod string, cmd []string) bool) (*httptest.Server, func() map[string][]string) {
+	handler, commands := newExecHandler(fail)
+	return httptest.NewServer(handler), commands
+}
+
+func newExecHandler(fail func(pod string, cmd []string) bool) (http.Handler, func() map[string][]string) {
+	var (
+		mu       sync.Mutex
+		commands = map[string][]string{}
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths := strings.Split(r.URL.Path, "/")
+		if len(paths) < 2 || paths[len(paths)-1] != "exec" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		pod := paths[len(paths)-2]
+		cmd := r.URL.Query()["command"]
+		mu.Lock()
+		commands[pod] = append(commands[pod], strings.Join(cmd, " "))
+		mu.Unlock()
+		if fail(pod, cmd) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		conn := spdystream.NewResponseUpgrader().UpgradeResponse(w, r, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+			go func() {
+				<-replySent
+				switch stream.Headers().Get(apiv1.StreamType) {
+				case apiv1.StreamTypeStdout, apiv1.StreamTypeStderr:
+					stream.Close()
+				}
+			}()
+			return nil
+		})
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		<-conn.CloseChan()
+	})
+	return handler, func() map[string][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return commands
+	}
+}
+
+func Test_CancelExecutorClosesConnection(t *testing.T) {
+	var (
+		connected = make(chan struct{})
+		closed    = make(chan struct{})
+	)
+	execHandler, _ := newExecHandler(func(string, []string) bool { return false })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/blocking/") {
+			execHandler.ServeHTTP(w, r)
+			return
+		}
+		// the command never finishes until the connection is closed by the client.
+		conn := spdystream.NewResponseUpgrader().UpgradeResponse(w, r, func(httpstream.Stream, <-chan struct{}) error {
+			return nil
+		})
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		close(connected)
+		<-conn.CloseChan()
+		close(closed)
+	}))
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	canceled := job.NewExecutor(
+		&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "blocking", Namespace: "default"}},
+		apiv1.Container{Name: "canceled"},
+	)
+	completed := job.NewExecutor(
+		&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}},
+		apiv1.Container{Name: "completed"},
+	)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := canceled.ExecOnce([]string{"sleep", "3600"})
+		errCh <- err
+	}()
+	select {
+	case <-connected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("failed to connect")
+	}
+	if err := canceled.Cancel(); err != nil {
+		t.Fatalf("failed to cancel: %+v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the connection of the canceled command to be closed")
+	}
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("expected error by the cancellation")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("canceled command did not return")
+	}
+	// the other executor is not affected.
+	if _, err := completed.ExecOnce([]string{"echo", "hello"}); err != nil {
+		t.Fatalf("failed to exec: %+v", err)
+	}
+}
+
+func Test_RetryOnEvictionWithExecutionHandler(t *testing.T) {
+	defer kubejob.SetExecRetryCount(1)()
+
+	// the command of the main container fails in the evicted pod.
+	server, executedCommands := newExecServer(func(pod string, cmd []string) bool {
+		return pod == "evicted" && !strings.Contains(strings.Join(cmd, " "), "status")
+	})
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").BuildWithJob(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubejob-",
+		},
+		Spec: batchv1.JobSpec{
+			Template: apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					InitContainers: []apiv1.Container{
+						{
+							Name:    "init",
+							Image:   goImageName,
+							Command: []string{"echo", "init"},
+						},
+					},
+					Containers: []apiv1.Container{
+						{
+							Name:    "main",
+							Image:   goImageName,
+							Command: []string{"echo", "main"},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	newPod := func(name string, phase apiv1.PodPhase) *apiv1.Pod {
+		pod := &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: apiv1.PodSpec{
+				// the command is replaced to control the execution timing.
+				InitContainers: []apiv1.Container{{Name: "init", Command: []string{"sh"}}},
+				Containers:     []apiv1.Container{{Name: "main", Command: []string{"sh"}}},
+			},
+			Status: apiv1.PodStatus{Phase: phase},
+		}
+		running := apiv1.ContainerState{Running: &apiv1.ContainerStateRunning{}}
+		switch phase {
+		case apiv1.PodPending:
+			pod.Status.InitContainerStatuses = []apiv1.ContainerStatus{{Name: "init", State: running}}
+		case apiv1.PodRunning:
+			pod.Status.ContainerStatuses = []apiv1.ContainerStatus{{Name: "main", Ready: true, State: running}}
+		}
+		return pod
+	}
+	evictedPod := newPod("evicted", apiv1.PodFailed)
+	evictedPod.Status.Reason = "Evicted"
+
+	var watchCount int32
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		pods := []*apiv1.Pod{newPod("evicted", apiv1.PodPending), newPod("evicted", apiv1.PodRunning), evictedPod}
+		if atomic.AddInt32(&watchCount, 1) > 1 {
+			pods = []*apiv1.Pod{newPod("recreated", apiv1.PodPending), newPod("recreated", apiv1.PodRunning), newPod("recreated", apiv1.PodSucceeded)}
+		}
+		watcher := watch.NewFake()
+		go func() {
+			for _, pod := range pods {
+				watcher.Modify(pod)
+			}
+		}()
+		return true, watcher, nil
+	})
+	job.SetClientset(clientset, "default")
+	job.SetLogContainers()
+	job.SetLogger(func(string) {})
+	job.SetRetryOnEviction(1)
+
+	var (
+		initPods    []string
+		handlerPods []string
+	)
+	if err := job.RunWithAllExecutionHandlers(context.Background(), func(exec *kubejob.JobExecutor) error {
+		initPods = append(initPods, exec.Pod.Name)
+		return nil
+	}, func(executors []*kubejob.JobExecutor) error {
+		handlerPods = append(handlerPods, executors[0].Pod.Name)
+		_, _ = executors[0].Exec()
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if strings.Join(initPods, ",") != "evicted,recreated" {
+		t.Fatalf("unexpected pods of the init handler: %v", initPods)
+	}
+	if strings.Join(handlerPods, ",") != "evicted,recreated" {
+		t.Fatalf("unexpected pods of the execution handler: %v", handlerPods)
+	}
+	// both the init container and the main container of the recreated pod must be stopped with the success status.
+	var stopped int
+	for _, cmd := range executedCommands()["recreated"] {
+		if strings.Contains(cmd, "echo 0 >") {
+			stopped++
+		}
+	}
+	if stopped != 2 {
+		t.Fatalf("unexpected commands in the recreated pod: %v", executedCommands()["recreated"])
+	}
+}
+
+func Test_SetStdinFileSpec(t *testing.T) {
+	f, err := os.CreateTemp("", "kubejob-stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("hello\nworld\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"cat"}).
+		SetStdinFile(f.Name()).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	spec := job.Spec.Template.Spec
+	// the content must not be embedded in the pod spec. the init container to copy it is added at running.
+	if len(spec.InitContainers) != 0 {
+		t.Fatalf("unexpected init containers: %+v", spec.InitContainers)
+	}
+	if len(spec.Volumes) != 1 || spec.Volumes[0].EmptyDir == nil {
+		t.Fatalf("unexpected volumes: %+v", spec.Volumes)
+	}
+	main := spec.Containers[0]
+	if strings.Join(main.Command, " ") != `sh -c exec "$0" "$@" < /kubejob/stdin/stdin` {
+		t.Fatalf("unexpected command: %v", main.Command)
+	}
+	if strings.Join(main.Args, " ") != "cat" {
+		t.Fatalf("unexpected args: %v", main.Args)
+	}
+
+	if _, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"cat"}).
+		SetStdinFile(filepath.Join(os.TempDir(), "kubejob-not-found")).
+		Build(); err == nil {
+		t.Fatal("expected error for the file not found")
+	}
+}
+
+func Test_SetStdinFileCopy(t *testing.T) {
+	f, err := os.CreateTemp("", "kubejob-stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	server, executedCommands := newExecServer(func(string, []string) bool { return false })
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"cat"}).
+		SetStdinFile(f.Name()).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Status: apiv1.PodStatus{
+				Phase: apiv1.PodPending,
+				InitContainerStatuses: []apiv1.ContainerStatus{
+					{Name: "kubejob-stdin", State: apiv1.ContainerState{Running: &apiv1.ContainerStateRunning{}}},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Status:     apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+		},
+	})
+	var createdJob *batchv1.Job
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		createdJob = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).DeepCopy()
+		return false, nil, nil
+	})
+	job.SetClientset(clientset, "default")
+	job.SetLogContainers()
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	initContainers := createdJob.Spec.Template.Spec.InitContainers
+	if len(initContainers) != 1 || initContainers[0].Name != "kubejob-stdin" {
+		t.Fatalf("unexpected init containers: %+v", initContainers)
+	}
+	if mounts := initContainers[0].VolumeMounts; len(mounts) != 1 || mounts[0].MountPath != "/kubejob/stdin" {
+		t.Fatalf("unexpected volume mounts: %+v", mounts)
+	}
+	var copied bool
+	for _, cmd := range executedCommands()["test"] {
+		if strings.HasPrefix(cmd, "tar ") && strings.Contains(cmd, "/kubejob/stdin") {
+			copied = true
+		}
+	}
+	if !copied {
+		t.Fatalf("expected to copy the stdin file: %v", executedCommands())
+	}
+}
+
+func Test_SetStdinFile(t *testing.T) {
+	f, err := os.CreateTemp("", "kubejob-stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	content := "hello\nworld 'quoted' $HOME\n"
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	out, err := kubejob.NewJobBuilder(cfg, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"cat"}).
+		SetStdinFile(f.Name()).
+		RunOutput(context.Background())
+	if err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if out != strings.TrimSpace(content) {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+const jobTemplate = `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: kubejob-
+spec:
+  template:
+    spec:
+      containers:
+      - name: test
+        image: {{ .Image }}
+        command:
+        - echo
+{{- range .Words }}
+        - {{ . }}
+{{- end }}
+{{- if .WorkingDir }}
+        workingDir: {{ .WorkingDir }}
+{{- end }}
+`
+
+func Test_JobTemplateRender(t *testing.T) {
+	tmpl, err := kubejob.NewJobBuilder(&rest.Config{}, "default").LoadJobTemplate(strings.NewReader(jobTemplate))
+	if err != nil {
+		t.Fatalf("failed to load job template: %+v", err)
+	}
+	t.Run("render", func(t *testing.T) {
+		job, err := tmpl.Render(map[string]interface{}{
+			"Image":      goImageName,
+			"Words":      []string{"hello", "world"},
+			"WorkingDir": "/tmp",
+		})
+		if err != nil {
+			t.Fatalf("failed to render: %+v", err)
+		}
+		container := job.Spec.Template.Spec.Containers[0]
+		if container.Image != goImageName {
+			t.Fatalf("unexpected image: %s", container.Image)
+		}
+		if strings.Join(container.Command, " ") != "echo hello world" {
+			t.Fatalf("unexpected command: %v", container.Command)
+		}
+		if container.WorkingDir != "/tmp" {
+			t.Fatalf("unexpected working dir: %s", container.WorkingDir)
+		}
+	})
+	t.Run("required param", func(t *testing.T) {
+		if _, err := tmpl.Render(map[string]interface{}{
+			"Words":      []string{"hello"},
+			"WorkingDir": "",
+		}); err == nil {
+			t.Fatal("expected error for the missing param")
+		}
+	})
+}
+
+func Test_JobTemplate(t *testing.T) {
+	tmpl, err := kubejob.NewJobBuilder(cfg, "default").LoadJobTemplate(strings.NewReader(jobTemplate))
+	if err != nil {
+		t.Fatalf("failed to load job template: %+v", err)
+	}
+	job, err := tmpl.Render(map[string]interface{}{
+		"Image":      goImageName,
+		"Words":      []string{"hello", "template"},
+		"WorkingDir": "",
+	})
+	if err != nil {
+		t.Fatalf("failed to render: %+v", err)
+	}
+	job.DisableCommandLog()
+	var logs []string
+	job.SetContainerLogger(func(log *kubejob.ContainerLog) {
+		if !log.IsFinished {
+			logs = append(logs, log.Log)
+		}
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if strings.Join(logs, "") != "hello template\n" {
+		t.Fatalf("unexpected logs: %q", logs)
+	}
+}
+
+func Test_SetFSGroupSpec(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		RunAsNonRoot(1000, 1000).
+		SetFSGroup(2000).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	sc := job.Spec.Template.Spec.SecurityContext
+	if sc.FSGroup == nil || *sc.FSGroup != 2000 {
+		t.Fatalf("unexpected fsGroup: %v", sc.FSGroup)
+	}
+	if sc.RunAsUser == nil || *sc.RunAsUser != 1000 {
+		t.Fatalf("unexpected runAsUser: %v", sc.RunAsUser)
+	}
+}
+
+func Test_SetFSGroup(t *testing.T) {
+	runAs := func(uid int64) *apiv1.SecurityContext {
+		return &apiv1.SecurityContext{RunAsUser: &uid}
+	}
+	job, err := kubejob.NewJobBuilder(cfg, "default").
+		SetFSGroup(2000).
+		AddSharedVolume("shared", "/shared", "").
+		BuildWithJob(&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "kubejob-",
+			},
+			Spec: batchv1.JobSpec{
+				Template: apiv1.PodTemplateSpec{
+					Spec: apiv1.PodSpec{
+						InitContainers: []apiv1.Container{
+							{
+								Name:            "writer",
+								Image:           goImageName,
+								Command:         []string{"sh", "-c", "echo -n hello > /shared/writer.txt && chmod g+w /shared/writer.txt"},
+								SecurityContext: runAs(1000),
+							},
+						},
+						Containers: []apiv1.Container{
+							{
+								Name:            "reader",
+								Image:           goImageName,
+								Command:         []string{"sh", "-c", "echo -n ' world' >> /shared/writer.txt && cat /shared/writer.txt"},
+								SecurityContext: runAs(1001),
+							},
+						},
+					},
+				},
+			},
+		})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.DisableCommandLog()
+	var out string
+	job.SetContainerLogger(func(log *kubejob.ContainerLog) {
+		if log.Container.Name == "reader" && !log.IsFinished {
+			out += log.Log
+		}
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if out != "hello world" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func Test_RunAndCollectLogs(t *testing.T) {
+	job, err := kubejob.NewJobBuilder(cfg, "default").BuildWithJob(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubejob-",
+		},
+		Spec: batchv1.JobSpec{
+			Template: apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{
+					InitContainers: []apiv1.Container{
+						{
+							Name:    "init",
+							Image:   goImageName,
+							Command: []string{"echo", "from init"},
+						},
+					},
+					Containers: []apiv1.Container{
+						{
+							Name:    "main",
+							Image:   goImageName,
+							Command: []string{"echo", "from main"},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	logs, err := job.RunAndCollectLogs(context.Background())
+	if err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if string(logs["init"]) != "from init\n" {
+		t.Fatalf("unexpected log of init container: %q", logs["init"])
+	}
+	if string(logs["main"]) != "from main\n" {
+		t.Fatalf("unexpected log of main container: %q", logs["main"])
+	}
+}
+
+func Test_RunAndCollectLogsFastCompletion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "hello")
+	}))
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: apiv1.PodSpec{
+			InitContainers: []apiv1.Container{{Name: "init"}},
+			Containers:     []apiv1.Container{{Name: "main"}},
+		},
+		// the pod has already completed when it's observed first.
+		Status: apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{pod}, pod.DeepCopy())
+	job.SetClientset(clientset, "default")
+	logs, err := job.RunAndCollectLogs(context.Background())
+	if err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	for _, name := range []string{"init", "main"} {
+		if string(logs[name]) != "hello\n" {
+			t.Fatalf("unexpected log of %s: %q", name, logs[name])
+		}
+	}
+}
+
+func Test_RunAfterRunAndCollectLogs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "hello")
+	}))
+	defer server.Close()
+
+	job, err := kubejob.NewJobBuilder(&rest.Config{Host: server.URL}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"echo", "hello"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{Name: kubejob.DefaultContainerName, Command: []string{"echo", "hello"}}},
+		},
+		Status: apiv1.PodStatus{Phase: apiv1.PodSucceeded},
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{pod}, pod.DeepCopy())
+	job.SetClientset(clientset, "default")
+	if _, err := job.RunAndCollectLogs(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	var logs []string
+	job.SetContainerLogger(func(cl *kubejob.ContainerLog) {
+		logs = append(logs, cl.Log)
+	})
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run: %+v", err)
+	}
+	if len(logs) == 0 || logs[0] != "echo hello\n" {
+		t.Fatalf("expected the command log to be restored: %q", logs)
+	}
+}
+
+func Test_DeletePodOnContextDeadline(t *testing.T) {
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			Labels:    map[string]string{kubejob.SelectorLabel: "deadline"},
+		},
+		Status: apiv1.PodStatus{Phase: apiv1.PodPending},
+	}
+	clientset := newFakeClientset([]*apiv1.Pod{pod}, pod.DeepCopy())
+	var (
+		mu      sync.Mutex
+		deleted []string
+	)
+	clientset.PrependReactor("delete", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		deleted = append(deleted, action.GetResource().Resource)
+		return false, nil, nil
+	})
+	job, err := kubejob.NewJobBuilder(&rest.Config{}, "default").
+		SetImage(goImageName).
+		SetCommand([]string{"sleep", "3600"}).
+		AddMutator(func(job *batchv1.Job) error {
+			job.Spec.Template.Labels[kubejob.SelectorLabel] = "deadline"
+			return nil
+		}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build job: %+v", err)
+	}
+	job.SetClientset(clientset, "default")
+	job.DisableContainerLog()
+	job.DisableCommandLog()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	startedAt := time.Now()
+	err = job.Run(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded but got %+v", err)
+	}
+	if elapsed := time.Since(startedAt); elapsed > 5*time.Second {
+		t.Fatalf("failed to stop promptly: %s", elapsed)
+	}
+	if _, err := clientset.CoreV1().Pods("default").Get(context.Background(), "test", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected to delete the pod: %+v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// the pod must be deleted before the Job is deleted by the cleanup process.
+	if len(deleted) == 0 || deleted[0] != "pods" {
+		t.Fatalf("expected to delete the pod first: %v", deleted)
+	}
+}
@@ -0,0 +1,124 @@
+package kubejob
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// BuildFromManifest builds the Job from the multi-document YAML.
+// The manifest must contain exactly one Job, and can contain ConfigMap and Secret as supporting resources.
+// The supporting resources are created before the Job is created, and they are deleted at cleanup of the Job.
+// The supporting resources must be in the same namespace as the Job.
+func (b *JobBuilder) BuildFromManifest(r io.Reader) (*Job, error) {
+	var (
+		jobSpec    *batchv1.Job
+		configMaps []*corev1.ConfigMap
+		secrets    []*corev1.Secret
+	)
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	decoder := scheme.Codecs.UniversalDeserializer()
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errInvalidYAML(err)
+		}
+		if len(doc) == 0 {
+			continue
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, errInvalidYAML(err)
+		}
+		switch v := obj.(type) {
+		case *batchv1.Job:
+			if jobSpec != nil {
+				return nil, errInvalidYAML(fmt.Errorf("manifest must contain only one job"))
+			}
+			jobSpec = v
+		case *corev1.ConfigMap:
+			if err := b.validateManifestNamespace(v.ObjectMeta, "configmap"); err != nil {
+				return nil, err
+			}
+			configMaps = append(configMaps, v)
+		case *corev1.Secret:
+			if err := b.validateManifestNamespace(v.ObjectMeta, "secret"); err != nil {
+				return nil, err
+			}
+			secrets = append(secrets, v)
+		default:
+			return nil, errInvalidYAML(fmt.Errorf("unsupported resource %s", obj.GetObjectKind().GroupVersionKind().Kind))
+		}
+	}
+	if jobSpec == nil {
+		return nil, errInvalidYAML(fmt.Errorf("manifest doesn't contain job"))
+	}
+	job, err := b.BuildWithJob(jobSpec)
+	if err != nil {
+		return nil, err
+	}
+	job.manifestConfigMaps = configMaps
+	job.manifestSecrets = secrets
+	return job, nil
+}
+
+// validateManifestNamespace rejects the supporting resource in the other namespace than the Job
+// because it is always created in the namespace of the Job.
+func (b *JobBuilder) validateManifestNamespace(meta metav1.ObjectMeta, kind string) error {
+	if meta.Namespace == "" || meta.Namespace == b.namespace {
+		return nil
+	}
+	return errInvalidYAML(
+		fmt.Errorf("namespace of %s %s is %s but the job namespace is %s", kind, meta.Name, meta.Namespace, b.namespace),
+	)
+}
+
+func (j *Job) createManifestResources(ctx context.Context) error {
+	for _, configMap := range j.manifestConfigMaps {
+		j.logDebug("create configmap %s", configMap.Name)
+		created, err := j.configMapClient.Create(ctx, configMap, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("job: failed to create configmap %s: %w", configMap.Name, err)
+		}
+		// record the name assigned by the server because the resource may use generateName.
+		j.createdConfigMapNames = append(j.createdConfigMapNames, created.Name)
+	}
+	for _, secret := range j.manifestSecrets {
+		j.logDebug("create secret %s", secret.Name)
+		created, err := j.secretClient.Create(ctx, secret, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("job: failed to create secret %s: %w", secret.Name, err)
+		}
+		j.createdSecretNames = append(j.createdSecretNames, created.Name)
+	}
+	return nil
+}
+
+func (j *Job) cleanupManifestResources(ctx context.Context) []error {
+	errs := []error{}
+	for _, name := range j.createdConfigMapNames {
+		j.logDebug("delete configmap %s", name)
+		if err := j.configMapClient.Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete configmap %s: %w", name, err))
+		}
+	}
+	for _, name := range j.createdSecretNames {
+		j.logDebug("delete secret %s", name)
+		if err := j.secretClient.Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete secret %s: %w", name, err))
+		}
+	}
+	j.createdConfigMapNames = nil
+	j.createdSecretNames = nil
+	return errs
+}
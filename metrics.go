@@ -0,0 +1,35 @@
+package kubejob
+
+import (
+	"context"
+	"time"
+)
+
+// JobOutcome is the result of running the Job that is passed to MetricsCollector.
+type JobOutcome string
+
+const (
+	JobOutcomeSucceeded JobOutcome = "succeeded"
+	JobOutcomeFailed    JobOutcome = "failed"
+	// JobOutcomeCancelled is the outcome when Run is stopped by the context ( cancellation or deadline ).
+	JobOutcomeCancelled JobOutcome = "cancelled"
+)
+
+// MetricsCollector collects the metrics of the Job.
+// The implementation for Prometheus is provided by github.com/goccy/kubejob/metrics package ( metrics.NewMetricsCollector ).
+type MetricsCollector interface {
+	// JobCreated is called when the Job is created.
+	JobCreated(namespace string)
+	// JobFinished is called when Run returns after the Job is created.
+	JobFinished(namespace string, outcome JobOutcome, duration time.Duration)
+}
+
+func jobOutcome(ctx context.Context, err error) JobOutcome {
+	if ctx.Err() != nil {
+		return JobOutcomeCancelled
+	}
+	if err != nil {
+		return JobOutcomeFailed
+	}
+	return JobOutcomeSucceeded
+}
@@ -0,0 +1,76 @@
+// Package metrics provides the Prometheus collector that records the metrics of the jobs run by kubejob.
+package metrics
+
+import (
+	"time"
+
+	"github.com/goccy/kubejob"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "kubejob"
+	subsystem = "job"
+)
+
+// Collector implements both kubejob.MetricsCollector and prometheus.Collector.
+// Register it to the prometheus registry and pass it to `(*kubejob.Job).SetMetricsCollector`.
+type Collector struct {
+	created  *prometheus.CounterVec
+	finished *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+var _ kubejob.MetricsCollector = (*Collector)(nil)
+
+// NewMetricsCollector creates the collector of the following metrics.
+//
+//	kubejob_job_created_total{namespace}
+//	kubejob_job_finished_total{namespace, outcome}
+//	kubejob_job_duration_seconds{namespace, outcome}
+//
+// The outcome is one of succeeded, failed and cancelled.
+func NewMetricsCollector() *Collector {
+	return &Collector{
+		created: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "created_total",
+			Help:      "The number of created jobs.",
+		}, []string{"namespace"}),
+		finished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "finished_total",
+			Help:      "The number of finished jobs by outcome.",
+		}, []string{"namespace", "outcome"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "duration_seconds",
+			Help:      "The duration of running the job by outcome.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		}, []string{"namespace", "outcome"}),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.created.Describe(ch)
+	c.finished.Describe(ch)
+	c.duration.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.created.Collect(ch)
+	c.finished.Collect(ch)
+	c.duration.Collect(ch)
+}
+
+func (c *Collector) JobCreated(namespace string) {
+	c.created.WithLabelValues(namespace).Inc()
+}
+
+func (c *Collector) JobFinished(namespace string, outcome kubejob.JobOutcome, duration time.Duration) {
+	c.finished.WithLabelValues(namespace, string(outcome)).Inc()
+	c.duration.WithLabelValues(namespace, string(outcome)).Observe(duration.Seconds())
+}
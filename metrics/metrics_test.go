@@ -0,0 +1,59 @@
+package metrics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goccy/kubejob"
+	"github.com/goccy/kubejob/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_Collector(t *testing.T) {
+	collector := metrics.NewMetricsCollector()
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf("failed to register collector: %+v", err)
+	}
+	collector.JobCreated("default")
+	collector.JobCreated("default")
+	collector.JobFinished("default", kubejob.JobOutcomeSucceeded, time.Second)
+	collector.JobFinished("default", kubejob.JobOutcomeCancelled, time.Second)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %+v", err)
+	}
+	var (
+		created  float64
+		finished = map[string]float64{}
+		samples  uint64
+	)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			switch family.GetName() {
+			case "kubejob_job_created_total":
+				created += metric.GetCounter().GetValue()
+			case "kubejob_job_finished_total":
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "outcome" {
+						finished[label.GetValue()] += metric.GetCounter().GetValue()
+					}
+				}
+			case "kubejob_job_duration_seconds":
+				samples += metric.GetHistogram().GetSampleCount()
+			default:
+				t.Fatalf("unexpected metric: %s", family.GetName())
+			}
+		}
+	}
+	if created != 2 {
+		t.Fatalf("unexpected created count: %v", created)
+	}
+	if finished["succeeded"] != 1 || finished["cancelled"] != 1 {
+		t.Fatalf("unexpected finished count: %v", finished)
+	}
+	if samples != 2 {
+		t.Fatalf("unexpected duration sample count: %v", samples)
+	}
+}
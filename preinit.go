@@ -1,6 +1,7 @@
 package kubejob
 
 import (
+	"context"
 	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
@@ -9,12 +10,56 @@ import (
 // PreInit can define the process you want to execute before the process of init container specified at the time of starting Job.
 // It is mainly intended to be used when you use `(*JobExecutor).CopyToFile` to copy an arbitrary file to pod before init processing.
 func (j *Job) PreInit(c corev1.Container, cb func(exec *JobExecutor) error) {
+	var callback func(context.Context, *JobExecutor) error
+	if cb != nil {
+		callback = func(_ context.Context, exec *JobExecutor) error {
+			return cb(exec)
+		}
+	}
+	j.PreInitWithContext(c, callback)
+}
+
+// PreInitWithContext is the same as PreInit, but the callback receives the context of Run.
+// If the context is canceled while the callback is running, the running command of the executor is canceled
+// and Run aborts without waiting for the callback to return.
+func (j *Job) PreInitWithContext(c corev1.Container, cb func(ctx context.Context, exec *JobExecutor) error) {
 	j.preInit = &preInit{
 		container: c,
 		callback:  cb,
 	}
 }
 
+// setupStdinFile copies the file specified by SetStdinFile to the shared volume by the preinit container.
+// If PreInit is already used, its container mounts the volume and copies the file before calling the callback.
+func (j *Job) setupStdinFile() {
+	if j.stdinFile == "" || j.stdinFileApplied || len(j.Spec.Template.Spec.Containers) == 0 {
+		return
+	}
+	j.stdinFileApplied = true
+	if j.preInit == nil {
+		j.preInit = &preInit{
+			container: corev1.Container{
+				Name:  stdinInitContainerName,
+				Image: j.Spec.Template.Spec.Containers[0].Image,
+			},
+		}
+	}
+	j.preInit.container.VolumeMounts = append(
+		append([]corev1.VolumeMount{}, j.preInit.container.VolumeMounts...),
+		stdinVolumeMount(),
+	)
+	callback := j.preInit.callback
+	j.preInit.callback = func(ctx context.Context, exec *JobExecutor) error {
+		if err := exec.CopyToPod(j.stdinFile, stdinPath); err != nil {
+			return err
+		}
+		if callback != nil {
+			return callback(ctx, exec)
+		}
+		return nil
+	}
+}
+
 func (j *Job) setupPreInitContainer() error {
 	if j.preInit == nil {
 		return nil
@@ -29,7 +74,7 @@ func (j *Job) setupPreInitContainer() error {
 		agentPort = port
 		c.Env = append(c.Env, j.agentCfg.PublicKeyEnv())
 	}
-	j.preInit.container = jobTemplateCommandContainer(c, j.agentCfg, agentPort)
+	j.preInit.container = jobTemplateCommandContainer(c, j.agentCfg, agentPort, j.jobExecutionWrapper())
 	j.preInit.exec = &JobExecutor{
 		Container: c,
 		command:   c.Command,
@@ -44,7 +89,7 @@ func (j *Job) setupPreInitContainer() error {
 type preInit struct {
 	exec      *JobExecutor
 	container corev1.Container
-	callback  func(*JobExecutor) error
+	callback  func(context.Context, *JobExecutor) error
 	done      bool
 }
 
@@ -69,7 +114,16 @@ func (i *preInit) needsToRun(status corev1.PodStatus) bool {
 	return false
 }
 
-func (i *preInit) run(pod *corev1.Pod) error {
+// reset clears the state of the previous execution to call the callback again for the new pod.
+func (i *preInit) reset() {
+	if i == nil {
+		return
+	}
+	i.done = false
+	i.exec.reset()
+}
+
+func (i *preInit) run(ctx context.Context, pod *corev1.Pod) error {
 	if i.done {
 		return nil
 	}
@@ -86,8 +140,19 @@ func (i *preInit) run(pod *corev1.Pod) error {
 		}
 	}
 	if i.callback != nil {
-		if err := i.callback(i.exec); err != nil {
-			return errPreInit(err)
+		done := make(chan error, 1)
+		go func() {
+			done <- i.callback(ctx, i.exec)
+		}()
+		select {
+		case <-ctx.Done():
+			// abort the hung command. the callback is expected to return after that.
+			_ = i.exec.Cancel()
+			return errPreInit(ctx.Err())
+		case err := <-done:
+			if err != nil {
+				return errPreInit(err)
+			}
 		}
 	}
 	i.done = true
@@ -0,0 +1,93 @@
+package kubejob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// knownSidecarShutdownCommands is the commands to shutdown the well-known service mesh proxies in the order of trial.
+// The shutdown endpoints of the proxies only accept the requests from localhost,
+// so the commands are executed in the sidecar container instead of calling them via the API server proxy.
+var knownSidecarShutdownCommands = map[string][][]string{
+	"istio-proxy": {
+		{"pilot-agent", "request", "POST", "quitquitquit"},
+		{"curl", "-sf", "-XPOST", "http://localhost:15020/quitquitquit"},
+	},
+	"linkerd-proxy": {
+		{"curl", "-sf", "-XPOST", "http://localhost:4191/shutdown"},
+	},
+}
+
+// sidecarTerminateCommand is the last resort to shutdown the sidecar.
+var sidecarTerminateCommand = []string{"kill", "-TERM", "1"}
+
+// sidecarContainers returns the containers that are not specified by the Job ( e.g. injected by service mesh )
+// and the containers that have the well-known sidecar name.
+func (j *Job) sidecarContainers(pod *corev1.Pod) []corev1.Container {
+	specified := map[string]struct{}{}
+	for _, c := range j.Job.Spec.Template.Spec.Containers {
+		if _, exists := knownSidecarShutdownCommands[c.Name]; exists {
+			continue
+		}
+		specified[c.Name] = struct{}{}
+	}
+	var sidecars []corev1.Container
+	for _, c := range pod.Spec.Containers {
+		if _, exists := specified[c.Name]; exists {
+			continue
+		}
+		sidecars = append(sidecars, c)
+	}
+	return sidecars
+}
+
+// isFinishedMainContainers returns true if all containers except sidecars have been terminated.
+func (j *Job) isFinishedMainContainers(pod *corev1.Pod) bool {
+	sidecars := map[string]struct{}{}
+	for _, c := range j.sidecarContainers(pod) {
+		sidecars[c.Name] = struct{}{}
+	}
+	if len(sidecars) == 0 {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if _, exists := sidecars[status.Name]; exists {
+			continue
+		}
+		if status.State.Terminated == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// shutdownSidecars terminates the sidecar containers so that the pod can reach the Completed state.
+// If the sidecar is the well-known proxy, executes its shutdown commands in order.
+// Otherwise ( or if all of them fail ), sends SIGTERM to the main process of the sidecar.
+func (j *Job) shutdownSidecars(ctx context.Context, pod *corev1.Pod) error {
+	for _, sidecar := range j.sidecarContainers(pod) {
+		exec := &JobExecutor{
+			Container: sidecar,
+			Pod:       pod,
+			job:       j,
+		}
+		var err error
+		for _, cmd := range append(knownSidecarShutdownCommands[sidecar.Name], sidecarTerminateCommand) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// the proxy image may not have the shell ( e.g. distroless ), so the command is executed as is.
+			if _, err = exec.execCommand(cmd, false); err == nil {
+				break
+			}
+			j.logDebug("failed to shutdown sidecar %s by %q: %s", sidecar.Name, strings.Join(cmd, " "), err)
+		}
+		if err != nil {
+			return fmt.Errorf("job: failed to shutdown sidecar %s: %w", sidecar.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package kubejob
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// JobStatus is the status of the pod observed on every watch event.
+type JobStatus struct {
+	Pod        *corev1.Pod
+	Phase      corev1.PodPhase
+	Containers []*JobContainerStatus
+}
+
+// JobContainerStatus is the state of the container at the time the JobStatus is observed.
+type JobContainerStatus struct {
+	Name         string
+	Ready        bool
+	Running      bool
+	Terminated   *corev1.ContainerStateTerminated
+	RestartCount int32
+}
+
+func newJobStatus(pod *corev1.Pod) *JobStatus {
+	statuses := append(
+		append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+		pod.Status.ContainerStatuses...,
+	)
+	containers := make([]*JobContainerStatus, 0, len(statuses))
+	for _, status := range statuses {
+		containers = append(containers, &JobContainerStatus{
+			Name:         status.Name,
+			Ready:        status.Ready,
+			Running:      status.State.Running != nil,
+			Terminated:   status.State.Terminated,
+			RestartCount: status.RestartCount,
+		})
+	}
+	return &JobStatus{
+		Pod:        pod,
+		Phase:      pod.Status.Phase,
+		Containers: containers,
+	}
+}
+
+func (j *Job) sendStatus(ctx context.Context, pod *corev1.Pod) {
+	// the watch loop may be still running after Run returns by the cancelled context,
+	// so the status channel must not be closed while sending.
+	j.statusMu.Lock()
+	defer j.statusMu.Unlock()
+	if j.statusCh == nil || j.statusClosed {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case j.statusCh <- newJobStatus(pod):
+	}
+}
+
+// RunWithStatusChannel runs the Job and sends the status of the pod on every watch event.
+// The status channel is closed when the Job is finished, and then the result of the Job is sent to the error channel.
+// The status channel must be consumed until it is closed, otherwise the Job is blocked.
+func (j *Job) RunWithStatusChannel(ctx context.Context) (<-chan *JobStatus, <-chan error) {
+	statusCh := make(chan *JobStatus)
+	j.statusMu.Lock()
+	j.statusCh = statusCh
+	j.statusClosed = false
+	j.statusMu.Unlock()
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		err := j.Run(ctx)
+		j.statusMu.Lock()
+		j.statusClosed = true
+		close(statusCh)
+		j.statusMu.Unlock()
+		errCh <- err
+	}()
+	return statusCh, errCh
+}
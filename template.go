@@ -0,0 +1,40 @@
+package kubejob
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"text/template"
+)
+
+// JobTemplate is the reusable Job definition written in YAML with Go template placeholders ( e.g. {{ .Image }} ).
+// Since it's the Go template, loops and conditionals are also available.
+type JobTemplate struct {
+	builder *JobBuilder
+	tmpl    *template.Template
+}
+
+// LoadJobTemplate loads the Job template from r.
+// The Job is built by the builder when the template is rendered.
+func (b *JobBuilder) LoadJobTemplate(r io.Reader) (*JobTemplate, error) {
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("job: failed to read job template: %w", err)
+	}
+	// missingkey=error makes the params referenced by the template required.
+	tmpl, err := template.New("job").Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("job: failed to parse job template: %w", err)
+	}
+	return &JobTemplate{builder: b, tmpl: tmpl}, nil
+}
+
+// Render renders the template with params and builds the Job from the rendered manifest.
+// If the param referenced by the template is not provided, returns an error.
+func (t *JobTemplate) Render(params map[string]interface{}) (*Job, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, params); err != nil {
+		return nil, fmt.Errorf("job: failed to render job template: %w", err)
+	}
+	return t.builder.BuildWithReader(&buf)
+}
@@ -6,6 +6,24 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+const (
+	jobStatusFilePath = "/tmp/kubejob-status"
+	jobStdoutFilePath = "/tmp/kubejob-stdout"
+	jobStderrFilePath = "/tmp/kubejob-stderr"
+
+	windowsJobStatusFilePath = `C:\Windows\Temp\kubejob-status`
+	windowsJobStdoutFilePath = `C:\Windows\Temp\kubejob-stdout`
+	windowsJobStderrFilePath = `C:\Windows\Temp\kubejob-stderr`
+)
+
+// OS is the operating system of the container.
+type OS string
+
+const (
+	OSLinux   OS = "linux"
+	OSWindows OS = "windows"
+)
+
 const jobCommandTemplate = `
 while [ ! -f /tmp/kubejob-status ]
 do
@@ -15,12 +33,32 @@ done
 exit $(cat /tmp/kubejob-status)
 `
 
-func jobTemplateCommandContainer(c corev1.Container, agentCfg *AgentConfig, agentPort uint16) corev1.Container {
+const windowsJobCommandTemplate = `
+while (!(Test-Path ` + windowsJobStatusFilePath + `)) {
+    Start-Sleep -Seconds 1
+}
+
+exit [int](Get-Content ` + windowsJobStatusFilePath + `)
+`
+
+// ExecutionWrapper returns the command and args to replace the original ones of the container
+// when the container is controlled by the execution handler.
+type ExecutionWrapper func(container corev1.Container) (command []string, args []string)
+
+func defaultExecutionWrapper(_ corev1.Container) ([]string, []string) {
+	return []string{"sh", "-c"}, []string{jobCommandTemplate}
+}
+
+func windowsExecutionWrapper(_ corev1.Container) ([]string, []string) {
+	return []string{"powershell", "-Command"}, []string{windowsJobCommandTemplate}
+}
+
+func jobTemplateCommandContainer(c corev1.Container, agentCfg *AgentConfig, agentPort uint16, wrapper ExecutionWrapper) corev1.Container {
 	copied := c.DeepCopy()
 	if agentCfg != nil && agentCfg.Enabled(c.Name) {
 		replaceCommandByAgentCommand(copied, agentCfg.InstalledPath(c.Name), agentPort)
 	} else {
-		replaceCommandByJobTemplate(copied)
+		replaceCommandByJobTemplate(copied, wrapper)
 	}
 	return *copied
 }
@@ -32,7 +70,9 @@ func replaceCommandByAgentCommand(c *corev1.Container, path string, port uint16)
 	}
 }
 
-func replaceCommandByJobTemplate(c *corev1.Container) {
-	c.Command = []string{"sh", "-c"}
-	c.Args = []string{jobCommandTemplate}
+func replaceCommandByJobTemplate(c *corev1.Container, wrapper ExecutionWrapper) {
+	if wrapper == nil {
+		wrapper = defaultExecutionWrapper
+	}
+	c.Command, c.Args = wrapper(*c)
 }